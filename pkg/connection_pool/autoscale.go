@@ -0,0 +1,113 @@
+package connection_pool
+
+import (
+	"context"
+	"errors"
+	motmedelContext "github.com/Motmedel/utils_go/pkg/context"
+	"time"
+)
+
+// runAutoScaler runs autoScale whenever a getter starts waiting and every half ScaleDownDelay, until the pool is
+// closed.
+func (pool *ConnectionPool[T]) runAutoScaler() {
+	ctx := context.Background()
+
+	interval := pool.scaleInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastScaledAt := time.Now()
+	for {
+		select {
+		case <-pool.done:
+			return
+		case <-pool.scaleSignal:
+		case <-ticker.C:
+		}
+
+		if pool.autoScale(ctx, lastScaledAt) {
+			lastScaledAt = time.Now()
+		}
+
+		// ApplyConfig may have changed ScaleDownDelay.
+		if newInterval := pool.scaleInterval(); newInterval != interval {
+			interval = newInterval
+			ticker.Reset(interval)
+		}
+	}
+}
+
+// scaleInterval returns the interval at which runAutoScaler checks whether to scale the pool down.
+func (pool *ConnectionPool[T]) scaleInterval() time.Duration {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	return max(pool.ScaleDownDelay/2, time.Millisecond)
+}
+
+// autoScale raises MaxNumConnections if getters are waiting, or lowers it if none has waited and nothing has been
+// scaled since lastScaledAt, at least ScaleDownDelay ago, and more than MinNumConnections connections are idle. It
+// reports whether getters were waiting or the pool was scaled down, which restarts the ScaleDownDelay.
+func (pool *ConnectionPool[T]) autoScale(ctx context.Context, lastScaledAt time.Time) bool {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	if pool.MaxNumConnections <= 0 || pool.ScaleStep <= 0 {
+		return false
+	}
+
+	if pool.waiters.Len() > 0 {
+		pool.scaleUp()
+		return true
+	}
+
+	if time.Since(lastScaledAt) < pool.ScaleDownDelay || pool.connections.Len() <= pool.MinNumConnections {
+		return false
+	}
+	newMax := max(pool.MaxNumConnections-pool.ScaleStep, pool.MinNumConnections, 1)
+	if newMax >= pool.MaxNumConnections {
+		return false
+	}
+
+	if err := errors.Join(pool.resize(newMax)...); err != nil {
+		pool.logger().WarnContext(
+			motmedelContext.WithErrorContextValue(ctx, err),
+			"An error occurred when closing connections while scaling the pool down.",
+		)
+	}
+
+	return true
+}
+
+// scaleUp raises MaxNumConnections by ScaleStep at a time, up to HardMax, and hands the capacity to waiting getters
+// until none is left waiting or the remaining ones cannot make use of more capacity. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) scaleUp() {
+	// Woken getters are about to take what is available and need no more capacity.
+	for pool.waiters.Len() > pool.numWoken && (pool.HardMax <= 0 || pool.MaxNumConnections < pool.HardMax) {
+		numWaiters := pool.waiters.Len() - pool.numWoken
+
+		newMax := pool.MaxNumConnections + pool.ScaleStep
+		if pool.HardMax > 0 {
+			newMax = min(newMax, pool.HardMax)
+		}
+		pool.MaxNumConnections = newMax
+		pool.serveWaiters()
+
+		if pool.waiters.Len()-pool.numWoken >= numWaiters {
+			break
+		}
+	}
+}
+
+// signalScaleUp wakes the auto-scaler, if it is running, to raise MaxNumConnections for a getter that has started
+// waiting. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) signalScaleUp() {
+	if pool.scaleSignal == nil {
+		return
+	}
+
+	select {
+	case pool.scaleSignal <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,57 @@
+package connection_pool_test
+
+import (
+	"context"
+	"errors"
+	"github.com/vphpersson/connection_pool/pkg/connection_pool"
+	"testing"
+	"time"
+)
+
+func TestNew_WithAutoScale(t *testing.T) {
+	t.Parallel()
+
+	pool := connection_pool.New(
+		func() (*mockConnection, error) {
+			return newMockConnection()
+		},
+		connection_pool.WithMaxConnections[*mockConnection](1),
+		connection_pool.WithAutoScale[*mockConnection](1, 3, 20*time.Millisecond),
+	)
+	defer pool.Close()
+
+	var connections []*mockConnection
+	for range 3 {
+		ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+		conn, err := pool.GetContext(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		connections = append(connections, conn)
+	}
+	if n := pool.MaxConnections(); n != 3 {
+		t.Fatalf("expected the pool to be scaled up to 3 connections, got %d", n)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := pool.GetContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the pool not to be scaled beyond the hard maximum, got %v", err)
+	}
+
+	for _, conn := range connections {
+		pool.Put(t.Context(), conn, nil)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for pool.MaxConnections() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the pool to be scaled down to 1 connection, got %d", pool.MaxConnections())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := pool.IdleLen(); n != 1 {
+		t.Fatalf("expected the idle connections beyond the maximum to be closed, got %d idle connections", n)
+	}
+}
@@ -0,0 +1,180 @@
+package connection_pool
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	motmedelErrors "github.com/Motmedel/utils_go/pkg/errors"
+	connectionPoolErrors "github.com/vphpersson/connection_pool/pkg/errors"
+	"time"
+)
+
+// Config holds the tunable configuration of a pool, as persisted by MarshalConfig and reapplied by ApplyConfig.
+type Config struct {
+	MaxNumConnections         int             `json:"max_num_connections"`
+	MinNumConnections         int             `json:"min_num_connections"`
+	MaxCheckoutDuration       time.Duration   `json:"max_checkout_duration"`
+	ReclaimLeakedConnections  bool            `json:"reclaim_leaked_connections"`
+	LeakDetection             bool            `json:"leak_detection"`
+	MaxIdlePerAddr            int             `json:"max_idle_per_addr"`
+	Order                     ConnectionOrder `json:"order"`
+	MaxIdleConnections        int             `json:"max_idle_connections"`
+	MaxIdleMemoryBytes        int64           `json:"max_idle_memory_bytes"`
+	PerConnectionBytes        int64           `json:"per_connection_bytes"`
+	DegradedAfterDialFailures int             `json:"degraded_after_dial_failures"`
+	MaxTotalCreations         int             `json:"max_total_creations"`
+	MaxConcurrentCreates      int             `json:"max_concurrent_creates"`
+	MaxWaiters                int             `json:"max_waiters"`
+	MaxConnectionUses         int             `json:"max_connection_uses"`
+	MaxConnectionIdleTime     time.Duration   `json:"max_connection_idle_time"`
+	MaxConnectionLifetime     time.Duration   `json:"max_connection_lifetime"`
+	ScaleStep                 int             `json:"scale_step"`
+	HardMax                   int             `json:"hard_max"`
+	ScaleDownDelay            time.Duration   `json:"scale_down_delay"`
+}
+
+func (config *Config) validate() error {
+	if config.MinNumConnections < 0 {
+		return fmt.Errorf("%w: min num connections", connectionPoolErrors.ErrInvalidConfig)
+	}
+	if config.MaxCheckoutDuration < 0 {
+		return fmt.Errorf("%w: max checkout duration", connectionPoolErrors.ErrInvalidConfig)
+	}
+	if config.MaxIdlePerAddr < 0 {
+		return fmt.Errorf("%w: max idle per addr", connectionPoolErrors.ErrInvalidConfig)
+	}
+	if config.MaxIdleConnections < 0 {
+		return fmt.Errorf("%w: max idle connections", connectionPoolErrors.ErrInvalidConfig)
+	}
+	if config.MaxIdleMemoryBytes < 0 {
+		return fmt.Errorf("%w: max idle memory bytes", connectionPoolErrors.ErrInvalidConfig)
+	}
+	if config.PerConnectionBytes < 0 {
+		return fmt.Errorf("%w: per connection bytes", connectionPoolErrors.ErrInvalidConfig)
+	}
+	if config.DegradedAfterDialFailures < 0 {
+		return fmt.Errorf("%w: degraded after dial failures", connectionPoolErrors.ErrInvalidConfig)
+	}
+	if config.MaxTotalCreations < 0 {
+		return fmt.Errorf("%w: max total creations", connectionPoolErrors.ErrInvalidConfig)
+	}
+	if config.MaxConcurrentCreates < 0 {
+		return fmt.Errorf("%w: max concurrent creates", connectionPoolErrors.ErrInvalidConfig)
+	}
+	if config.MaxWaiters < 0 {
+		return fmt.Errorf("%w: max waiters", connectionPoolErrors.ErrInvalidConfig)
+	}
+	if config.MaxConnectionUses < 0 {
+		return fmt.Errorf("%w: max connection uses", connectionPoolErrors.ErrInvalidConfig)
+	}
+	if config.MaxConnectionIdleTime < 0 {
+		return fmt.Errorf("%w: max connection idle time", connectionPoolErrors.ErrInvalidConfig)
+	}
+	if config.MaxConnectionLifetime < 0 {
+		return fmt.Errorf("%w: max connection lifetime", connectionPoolErrors.ErrInvalidConfig)
+	}
+	if config.ScaleStep < 0 {
+		return fmt.Errorf("%w: scale step", connectionPoolErrors.ErrInvalidConfig)
+	}
+	if config.HardMax < 0 {
+		return fmt.Errorf("%w: hard max", connectionPoolErrors.ErrInvalidConfig)
+	}
+	if config.ScaleDownDelay < 0 {
+		return fmt.Errorf("%w: scale down delay", connectionPoolErrors.ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// config returns the pool's tunable configuration. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) config() Config {
+	return Config{
+		MaxNumConnections:         pool.MaxNumConnections,
+		MinNumConnections:         pool.MinNumConnections,
+		MaxCheckoutDuration:       pool.MaxCheckoutDuration,
+		ReclaimLeakedConnections:  pool.ReclaimLeakedConnections,
+		LeakDetection:             pool.LeakDetection,
+		MaxIdlePerAddr:            pool.MaxIdlePerAddr,
+		Order:                     pool.Order,
+		MaxIdleConnections:        pool.MaxIdleConnections,
+		MaxIdleMemoryBytes:        pool.MaxIdleMemoryBytes,
+		PerConnectionBytes:        pool.PerConnectionBytes,
+		DegradedAfterDialFailures: pool.DegradedAfterDialFailures,
+		MaxTotalCreations:         pool.MaxTotalCreations,
+		MaxConcurrentCreates:      pool.MaxConcurrentCreates,
+		MaxWaiters:                pool.MaxWaiters,
+		MaxConnectionUses:         pool.MaxConnectionUses,
+		MaxConnectionIdleTime:     pool.MaxConnectionIdleTime,
+		MaxConnectionLifetime:     pool.MaxConnectionLifetime,
+		ScaleStep:                 pool.ScaleStep,
+		HardMax:                   pool.HardMax,
+		ScaleDownDelay:            pool.ScaleDownDelay,
+	}
+}
+
+// MarshalConfig serializes the pool's tunable configuration as JSON. Connections and callbacks are not included.
+func (pool *ConnectionPool[T]) MarshalConfig() ([]byte, error) {
+	pool.mutex.Lock()
+	config := pool.config()
+	pool.unlock()
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, motmedelErrors.NewWithTrace(fmt.Errorf("json marshal: %w", err), config)
+	}
+
+	return data, nil
+}
+
+// ApplyConfig applies a configuration serialized by MarshalConfig. Fields missing from data keep their current
+// values. Unknown fields and invalid values are rejected, as is a change of Order while the pool holds idle
+// connections, in which case the configuration is left unchanged.
+func (pool *ConnectionPool[T]) ApplyConfig(data []byte) error {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	config := pool.config()
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return motmedelErrors.NewWithTrace(fmt.Errorf("json decode: %w", err), data)
+	}
+
+	if err := config.validate(); err != nil {
+		return motmedelErrors.NewWithTrace(err, config)
+	}
+	// The idle list is kept in reuse order, which a new order would not match.
+	if config.Order != pool.Order && pool.connections.Len() > 0 {
+		return motmedelErrors.NewWithTrace(
+			fmt.Errorf("%w: order changed with idle connections", connectionPoolErrors.ErrInvalidConfig),
+			config,
+		)
+	}
+
+	pool.MaxNumConnections = config.MaxNumConnections
+	pool.MinNumConnections = config.MinNumConnections
+	pool.MaxCheckoutDuration = config.MaxCheckoutDuration
+	pool.ReclaimLeakedConnections = config.ReclaimLeakedConnections
+	pool.LeakDetection = config.LeakDetection
+	pool.MaxIdlePerAddr = config.MaxIdlePerAddr
+	pool.Order = config.Order
+	pool.MaxIdleConnections = config.MaxIdleConnections
+	pool.MaxIdleMemoryBytes = config.MaxIdleMemoryBytes
+	pool.PerConnectionBytes = config.PerConnectionBytes
+	pool.DegradedAfterDialFailures = config.DegradedAfterDialFailures
+	pool.MaxTotalCreations = config.MaxTotalCreations
+	pool.MaxConcurrentCreates = config.MaxConcurrentCreates
+	pool.MaxWaiters = config.MaxWaiters
+	pool.MaxConnectionUses = config.MaxConnectionUses
+	pool.MaxConnectionIdleTime = config.MaxConnectionIdleTime
+	pool.MaxConnectionLifetime = config.MaxConnectionLifetime
+	pool.ScaleStep = config.ScaleStep
+	pool.HardMax = config.HardMax
+	pool.ScaleDownDelay = config.ScaleDownDelay
+
+	// A raised limit may let waiting getters proceed.
+	pool.serveWaiters()
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package connection_pool_test
+
+import (
+	"errors"
+	"github.com/vphpersson/connection_pool/pkg/connection_pool"
+	connectionPoolErrors "github.com/vphpersson/connection_pool/pkg/errors"
+	"testing"
+	"time"
+)
+
+func TestConnectionPool_MarshalApplyConfig(t *testing.T) {
+	t.Parallel()
+
+	makeConnection := func() (*mockConnection, error) {
+		return newMockConnection()
+	}
+
+	pool := connection_pool.New(makeConnection)
+	pool.MaxNumConnections = 12
+	pool.MaxCheckoutDuration = time.Minute
+	pool.MaxIdlePerAddr = 3
+	pool.Order = connection_pool.OrderFIFO
+	pool.ScaleStep = 2
+	pool.HardMax = 20
+	pool.ScaleDownDelay = time.Second
+
+	data, err := pool.MarshalConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	restored := connection_pool.New(makeConnection)
+	if err := restored.ApplyConfig(data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if restored.MaxNumConnections != 12 {
+		t.Fatalf("expected MaxNumConnections to be 12, got %d", restored.MaxNumConnections)
+	}
+	if restored.MaxCheckoutDuration != time.Minute {
+		t.Fatalf("expected MaxCheckoutDuration to be 1m, got %s", restored.MaxCheckoutDuration)
+	}
+	if restored.MaxIdlePerAddr != 3 {
+		t.Fatalf("expected MaxIdlePerAddr to be 3, got %d", restored.MaxIdlePerAddr)
+	}
+	if restored.Order != connection_pool.OrderFIFO {
+		t.Fatalf("expected Order to be fifo, got %s", restored.Order)
+	}
+	if restored.ScaleStep != 2 || restored.HardMax != 20 || restored.ScaleDownDelay != time.Second {
+		t.Fatalf(
+			"expected the auto-scaling settings to be restored, got %d, %d and %s",
+			restored.ScaleStep,
+			restored.HardMax,
+			restored.ScaleDownDelay,
+		)
+	}
+
+	// Missing fields keep their current values.
+	if err := restored.ApplyConfig([]byte(`{"max_idle_per_addr": 1}`)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if restored.MaxNumConnections != 12 || restored.MaxIdlePerAddr != 1 {
+		t.Fatal("expected only MaxIdlePerAddr to change")
+	}
+}
+
+func TestConnectionPool_ApplyConfigInvalid(t *testing.T) {
+	t.Parallel()
+
+	pool := connection_pool.New(func() (*mockConnection, error) {
+		return newMockConnection()
+	})
+
+	testCases := []struct {
+		name string
+		data string
+	}{
+		{name: "negative min num connections", data: `{"min_num_connections": -1}`},
+		{name: "negative duration", data: `{"max_checkout_duration": -1}`},
+		{name: "unknown order", data: `{"order": "random"}`},
+		{name: "negative scale step", data: `{"scale_step": -1}`},
+		{name: "unknown field", data: `{"max_num_sockets": 3}`},
+		{name: "malformed", data: `{`},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if err := pool.ApplyConfig([]byte(testCase.data)); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+
+	err := pool.ApplyConfig([]byte(`{"max_num_connections": 8, "min_num_connections": -1}`))
+	if !errors.Is(err, connectionPoolErrors.ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig, got %v", err)
+	}
+	if pool.MaxNumConnections != 5 {
+		t.Fatalf("expected the configuration to be unchanged, got MaxNumConnections %d", pool.MaxNumConnections)
+	}
+}
+
+func TestConnectionPool_ApplyConfigOrderWithIdleConnections(t *testing.T) {
+	t.Parallel()
+
+	pool := connection_pool.New(func() (*mockConnection, error) {
+		return newMockConnection()
+	})
+
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pool.Put(t.Context(), conn, nil)
+
+	err = pool.ApplyConfig([]byte(`{"order": "fifo", "max_idle_per_addr": 1}`))
+	if !errors.Is(err, connectionPoolErrors.ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig, got %v", err)
+	}
+	if pool.Order != connection_pool.OrderLIFO || pool.MaxIdlePerAddr != 0 {
+		t.Fatal("expected the configuration to be unchanged")
+	}
+
+	// The current order may be repeated.
+	if err := pool.ApplyConfig([]byte(`{"order": "lifo"}`)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -1,133 +1,2258 @@
+// Package connection_pool provides a pool of reusable connections, or of any other io.Closer.
+//
+// New code should check out connections with Acquire and return them with Release, passing the error, if any, that made
+// the connection unusable so that it is closed rather than reused:
+//
+//	conn, err := pool.Acquire(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	err = use(conn)
+//	pool.Release(ctx, conn, err)
+//
+// Do wraps this pattern around a function. Get and Put are kept for existing callers.
 package connection_pool
 
 import (
 	"container/list"
 	"context"
+	"errors"
 	"fmt"
 	motmedelContext "github.com/Motmedel/utils_go/pkg/context"
 	motmedelErrors "github.com/Motmedel/utils_go/pkg/errors"
 	connectionPoolErrors "github.com/vphpersson/connection_pool/pkg/errors"
 	"io"
 	"log/slog"
+	"math"
 	"net"
+	"reflect"
+	"runtime/debug"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+var _ io.Closer = (*ConnectionPool[io.Closer])(nil)
+var _ fmt.Stringer = (*ConnectionPool[io.Closer])(nil)
+var _ Pool[io.Closer] = (*ConnectionPool[io.Closer])(nil)
+
+// Unlimited may be assigned to MaxNumConnections to let the pool create connections on demand without ever making Get
+// wait for capacity. Any value of zero or less has the same effect.
+const Unlimited = -1
+
+// Pool is the subset of ConnectionPool that most callers use, for functions that should accept a pool without
+// depending on its implementation, e.g. so that tests can pass a testutil.MockPool instead.
+type Pool[T io.Closer] interface {
+	Get() (T, error)
+	Put(ctx context.Context, connection T, err error)
+	Close() error
+	IdleLen() int
+}
+
 type ConnectionPool[T io.Closer] struct {
+	// MaxNumConnections caps the number of connections, idle and active, held by the pool. Zero or less means no cap,
+	// in which case the pool only manages reuse and Get never waits for capacity.
+	//
+	// Deprecated: Accessing the field once the pool is shared races with the pool's methods; use WithMaxConnections,
+	// SetMaxConnections and MaxConnections instead.
 	MaxNumConnections int
 	MakeConnection    func() (T, error)
+	// MakeConnectionContext, if set instead of MakeConnection, creates connections with the context passed by the
+	// getter they are created for, e.g. to route on a tenant ID carried by it. Connections created in the background
+	// get a background context. If both are set, creating a connection fails with ErrInvalidConfig.
+	MakeConnectionContext func(context.Context) (T, error)
+
+	// MinNumConnections is the number of connections, idle or checked out, that WarmUp creates up front and that the
+	// pool refills in the background when a Put leaves it with fewer. Zero disables both.
+	MinNumConnections int
+
+	// MaxCheckoutDuration is how long a connection may be checked out before it is considered leaked by
+	// ScanCheckouts. Zero disables the check.
+	MaxCheckoutDuration time.Duration
+	// ReclaimLeakedConnections makes ScanCheckouts close leaked connections and free their slots rather than only
+	// logging a warning.
+	ReclaimLeakedConnections bool
+	// LeakDetection makes the pool record the stack trace of every checkout, for CheckLeaks to report. It is meant for
+	// tests and debugging, as capturing a stack trace is costly.
+	LeakDetection bool
+
+	// OnSaturated is called once when the pool becomes saturated, i.e. when a getter has to wait because every
+	// connection is checked out and no more may be created. OnUnsaturated is called once when a connection or capacity
+	// becomes available again with no getter left waiting. Both are called with the pool's mutex held, so they must
+	// return quickly and must not call methods on the pool.
+	OnSaturated   func()
+	OnUnsaturated func()
+
+	// MaxIdlePerAddr caps the number of idle connections to the same remote address, for connections that report one
+	// via a RemoteAddr method such as net.Conn. Put closes a returned connection rather than making it idle when the
+	// cap is reached. Zero disables the cap.
+	MaxIdlePerAddr int
+
+	// Order is the order in which idle connections are reused, OrderLIFO by default. It should be set before the pool
+	// holds idle connections, e.g. with WithConnectionOrder; ApplyConfig rejects a change while it holds any.
+	Order ConnectionOrder
+
+	// MaxIdleConnections caps the number of idle connections. Put closes a returned connection rather than making it
+	// idle when the cap is reached. Zero disables the cap.
+	MaxIdleConnections int
+
+	// MaxIdleMemoryBytes bounds the memory held by idle connections, as estimated by PerConnectionBytes per
+	// connection. Put closes the oldest idle connections while the estimate exceeds the bound. The bound is disabled
+	// unless both are positive.
+	MaxIdleMemoryBytes int64
+	PerConnectionBytes int64
+
+	// DegradedAfterDialFailures is the number of consecutive failures to create a connection after which Degraded
+	// reports the pool as degraded, until a connection is created successfully again. Zero disables the check.
+	DegradedAfterDialFailures int
+
+	// MaxTotalCreations caps the number of connections the pool creates over its lifetime. Once reached, idle
+	// connections are still reused, but a getter that would need a new connection gets ErrCreationLimitReached. Zero
+	// means no cap.
+	MaxTotalCreations int
+
+	// Equal reports whether two values are the same connection, which the pool needs in order to track checked-out
+	// connections. The default compares the values as interfaces, which suits pointer connection types. Connection
+	// types that are not comparable, or whose copies do not compare equal, must supply their own; without it, getters
+	// of a pool of connections that are not comparable get ErrInvalidConfig. Without Equal, the pool looks connections
+	// up by map key; with it, it compares a connection with each tracked connection in turn. Equal must be set before
+	// the pool is used.
+	Equal func(a, b T) bool
+
+	// ValidateConnection, if set, reports whether an idle connection is still usable before it is checked out. A
+	// rejected connection is closed and discarded, and the next idle connection is tried, until a fresh connection is
+	// created. Fresh connections are not validated. It is called with the pool's mutex held, so it must not call
+	// methods on the pool.
+	ValidateConnection func(T) bool
+	// ValidateOnPut, if set, reports whether a connection returned with Put and no error is still usable before it is
+	// made idle, e.g. that it has no unread data buffered. A rejected connection is handled as if it had been returned
+	// with an error wrapping ErrConnectionRejected. It is called without the pool's mutex held.
+	ValidateOnPut func(T) bool
+
+	// MaxConnectionIdleTime is how long a connection may sit idle before it is closed and discarded rather than
+	// checked out. Zero disables the check. If it is set with WithIdleTimeout, a background goroutine started by New
+	// and stopped by Close also closes expired idle connections every half MaxConnectionIdleTime, so that connections
+	// that are never checked out again do not linger.
+	MaxConnectionIdleTime time.Duration
+	// MaxConnectionUses is the number of times a connection may be checked out. Put closes a connection that has been
+	// checked out that many times rather than making it idle. Zero disables the cap.
+	MaxConnectionUses int
+
+	// MaxConnectionLifetime is how long after its creation a connection may be checked out. An idle connection older
+	// than that is closed and discarded rather than checked out, and a fresh one is created in its place if needed.
+	// Zero disables the check.
+	MaxConnectionLifetime time.Duration
+
+	// IdleHealthCheck, if set together with IdleHealthCheckInterval, is run on every idle connection by a background
+	// goroutine each IdleHealthCheckInterval, and connections it rejects are closed and discarded. The goroutine is
+	// started by New and stopped by Close, so both must be set with options, e.g. WithBackgroundHealthCheck. The
+	// connections are checked one at a time, and a connection is not idle while being checked. IdleHealthCheck is called
+	// without the pool's mutex held. Unlike ValidateConnection, it is not run when a connection is checked out.
+	IdleHealthCheck         func(T) bool
+	IdleHealthCheckInterval time.Duration
+
+	// MaxWaiters caps the number of getters waiting for a connection. A getter that would have to wait beyond the cap
+	// gets ErrPoolExhausted instead. Zero disables the cap.
+	MaxWaiters int
+
+	// MaxConcurrentCreates caps the number of MakeConnection calls in progress at once, so that a burst of getters does
+	// not overwhelm the backend with connection attempts. Getters beyond the cap wait for a call to finish. Zero
+	// disables the cap.
+	MaxConcurrentCreates int
+
+	// AutoScale, if set together with ScaleStep, makes a background goroutine adjust MaxNumConnections to demand. As
+	// soon as getters wait for capacity, MaxNumConnections is raised by ScaleStep, up to HardMax, until none is left
+	// waiting. Once no getter has waited for ScaleDownDelay and more than MinNumConnections connections are idle, it is
+	// lowered by ScaleStep, down to MinNumConnections, closing idle connections as Resize does, and again after each
+	// further ScaleDownDelay. The goroutine is started by New and stopped by Close, so AutoScale must be set with
+	// WithAutoScale. A zero HardMax does not cap the raising. A pool without a limit on its connections is not scaled.
+	AutoScale      bool
+	ScaleStep      int
+	HardMax        int
+	ScaleDownDelay time.Duration
+
+	// RetryOnCreate, if set, is consulted when MakeConnection fails, and the creation is retried after the delay it
+	// returns until it gives up or the getter's context is done.
+	RetryOnCreate RetryPolicy
+
+	// Logger receives the pool's log messages. The default logger is used if it is nil.
+	Logger *slog.Logger
+
+	// OnGet is called with each connection checked out, OnPut with each connection returned and its error, before the
+	// pool handles it, and OnClose with each connection the pool closes and the error from closing it. They are called
+	// without the pool's mutex held.
+	OnGet   func(T)
+	OnPut   func(T, error)
+	OnClose func(T, error)
+	// OnCheckoutDuration is called with each connection returned with Put and how long it was checked out, after the
+	// pool has handled it and without the pool's mutex held, e.g. for building latency histograms. It is not called
+	// for connections that the pool did not hand out.
+	OnCheckoutDuration func(T, time.Duration)
 
 	numActiveConnections int
-	condition            *sync.Cond
 	connections          *list.List
-	mutex                *sync.Mutex
+	waiters              *list.List
+	saturated            bool
+	// numWoken is the number of waiting getters that have been woken to compete for a connection and have yet to try,
+	// and numStarving the number that have waited longer than starvationThreshold.
+	numWoken        int
+	numStarving     int
+	numDialFailures int
+	numCreations    int
+	// checkouts holds the entries of the checked-out connections, and checkoutIndex maps the connections to their
+	// entries.
+	checkouts     []*connEntry[T]
+	checkoutIndex connMap[T, *connEntry[T]]
+	// uncomparable reports whether T is not comparable, in which case connections cannot be map keys and the pool
+	// cannot tell them apart without Equal.
+	uncomparable bool
+	generation   int
+	idlePerAddr  map[string]int
+	// reclaimed holds the connections reclaimed by ScanCheckouts, and reclaimedOrder the same connections oldest first,
+	// so that the oldest can be forgotten.
+	reclaimed         connMap[T, struct{}]
+	reclaimedOrder    []T
+	getMiddleware     []Middleware[T]
+	putMiddleware     []PutMiddleware[T]
+	refilling         bool
+	draining          bool
+	closed            bool
+	done              chan struct{}
+	createSemaphore   chan struct{}
+	drained           chan struct{}
+	closedConnections []*closedConnection[T]
+	events            []PoolEvent
+	scaleSignal       chan struct{}
+	eventsNext        int
+	numGets           uint64
+	numPuts           uint64
+	numCreated        uint64
+	numClosed         uint64
+	numErrors         uint64
+	// numConnectionsCreated and numConnectionErrors are atomic so that they can be read without the mutex, and are
+	// never reset.
+	numConnectionsCreated atomic.Uint64
+	numConnectionErrors   atomic.Uint64
+	mutex                 *sync.Mutex
+}
+
+// LeakRecord describes a checkout of a connection, as recorded when LeakDetection is enabled.
+type LeakRecord[T io.Closer] struct {
+	// Connection is the checked-out connection.
+	Connection T
+	// CheckedOutAt is when the connection was checked out.
+	CheckedOutAt time.Time
+	// Stack is the stack trace of the goroutine that checked out the connection.
+	Stack []byte
+}
+
+// connEntry holds a connection together with what the pool knows about it. The idle list holds the entries of the idle
+// connections and checkouts those of the checked-out connections, so that an entry follows its connection around.
+type connEntry[T io.Closer] struct {
+	conn         T
+	createdAt    time.Time
+	lastIdledAt  time.Time
+	checkedOutAt time.Time
+	useCount     int
+	// generation is the pool's generation when the connection was created; connections of an earlier generation are
+	// replaced by RollConnections.
+	generation int
+	// idleElement is the connection's element in the idle list, or nil if the connection is not idle.
+	idleElement *list.Element
+	// checkoutPosition is the connection's position in checkouts while it is checked out.
+	checkoutPosition int
+	// leakRecord is the record of the connection's checkout, if it is checked out and LeakDetection was enabled.
+	leakRecord *LeakRecord[T]
+	// tag is the identifier attached to the connection with Tag, if any.
+	tag string
+}
+
+type closedConnection[T io.Closer] struct {
+	connection T
+	err        error
+}
+
+type waiter[T io.Closer] struct {
+	// ready is signalled when the getter has been served, i.e. served is set, or woken to compete for a connection.
+	ready         chan struct{}
+	element       *list.Element
+	priority      Priority
+	existingOnly  bool
+	connection    T
+	hasConnection bool
+	err           error
+	served        bool
+	// woken is set while the getter has been woken to compete for a connection and has yet to try, and starving once
+	// it has waited longer than starvationThreshold, after which it is handed what it waits for.
+	woken        bool
+	starving     bool
+	waitingSince time.Time
+
+	// batchSize is the number of connections wanted by a GetN waiter, which is served with batch, idle connections
+	// checked out on its behalf, and numReserved, slots reserved for it to create the rest.
+	batchSize   int
+	batch       []T
+	numReserved int
 }
 
-func New[T net.Conn](fn func() (T, error)) *ConnectionPool[T] {
-	mutex := new(sync.Mutex)
-	return &ConnectionPool[T]{
+// New returns a pool that creates connections with fn, configured by the options. Configuring the pool with options
+// rather than by assigning its fields after construction is recommended, as the options are applied before the pool
+// can be shared.
+func New[T io.Closer](fn func() (T, error), options ...Option[T]) *ConnectionPool[T] {
+	pool := &ConnectionPool[T]{
 		MaxNumConnections: 5,
 		MakeConnection:    fn,
-		mutex:             mutex,
+		mutex:             new(sync.Mutex),
 		connections:       list.New(),
-		condition:         sync.NewCond(mutex),
+		waiters:           list.New(),
+		idlePerAddr:       make(map[string]int),
+		done:              make(chan struct{}),
+		uncomparable:      !reflect.TypeFor[T]().Comparable(),
+	}
+
+	for _, option := range options {
+		option(pool)
+	}
+
+	if pool.IdleHealthCheck != nil && pool.IdleHealthCheckInterval > 0 {
+		go pool.runHealthChecks()
+	}
+	if pool.MaxConnectionIdleTime > 0 {
+		go pool.runIdleReaper(pool.MaxConnectionIdleTime / 2)
+	}
+	if pool.AutoScale && pool.ScaleStep > 0 {
+		pool.scaleSignal = make(chan struct{}, 1)
+		go pool.runAutoScaler()
+	}
+
+	return pool
+}
+
+// NewWithLimits is like New, but sets MinNumConnections to minIdle and MaxNumConnections to maxOpen before applying
+// the options. If minIdle is positive, the pool is warmed up in the background, as after Put; failures are logged.
+func NewWithLimits[T io.Closer](
+	fn func() (T, error),
+	minIdle int,
+	maxOpen int,
+	options ...Option[T],
+) *ConnectionPool[T] {
+	limits := []Option[T]{WithMinConnections[T](minIdle), WithMaxConnections[T](maxOpen)}
+	pool := New(fn, append(limits, options...)...)
+
+	if minIdle > 0 {
+		pool.mutex.Lock()
+		pool.startRefill()
+		pool.unlock()
 	}
+
+	return pool
+}
+
+// NewContext is like New, but sets MakeConnectionContext to fn rather than MakeConnection.
+func NewContext[T io.Closer](fn func(context.Context) (T, error), options ...Option[T]) *ConnectionPool[T] {
+	return New(nil, append([]Option[T]{WithMakeConnectionContext(fn)}, options...)...)
+}
+
+// NewNetConn is like New, but restricted to net.Conn connections, as New was before it accepted any io.Closer.
+func NewNetConn[T net.Conn](fn func() (T, error), options ...Option[T]) *ConnectionPool[T] {
+	return New(fn, options...)
+}
+
+// AcquireInfo describes how a connection was acquired.
+type AcquireInfo struct {
+	// Reused is true if the connection was an idle connection rather than a freshly created one.
+	Reused bool
+	// WaitDuration is how long the getter waited for a connection or capacity to become available.
+	WaitDuration time.Duration
+	// DialDuration is how long it took to create the connection; it is zero for reused connections.
+	DialDuration time.Duration
+}
+
+// Acquire checks out a connection, reusing an idle one or creating one if the pool has capacity, and otherwise waiting
+// until either becomes available or ctx is done. The connection must be returned with Release.
+func (pool *ConnectionPool[T]) Acquire(ctx context.Context) (T, error) {
+	connection, _, err := pool.get(ctx, true)
+	return connection, err
+}
+
+// Release returns a connection checked out from the pool. If err is not nil, the connection is considered unusable and
+// is closed rather than made idle.
+func (pool *ConnectionPool[T]) Release(ctx context.Context, connection T, err error) {
+	pool.Put(ctx, connection, err)
 }
 
 func (pool *ConnectionPool[T]) Get() (T, error) {
-	pool.mutex.Lock()
-	defer pool.mutex.Unlock()
+	connection, _, err := pool.get(context.Background(), true)
+	return connection, err
+}
+
+// GetContext is like Get, but stops waiting for a connection and returns the context's error when ctx is done.
+func (pool *ConnectionPool[T]) GetContext(ctx context.Context) (T, error) {
+	connection, _, err := pool.get(ctx, true)
+	return connection, err
+}
+
+// GetDetailed is like GetContext, but also reports how the connection was acquired.
+func (pool *ConnectionPool[T]) GetDetailed(ctx context.Context) (T, AcquireInfo, error) {
+	return pool.get(ctx, true)
+}
+
+// TryGet is like Get, but returns ErrPoolExhausted instead of waiting when the pool has no idle connection and no
+// capacity to create one.
+func (pool *ConnectionPool[T]) TryGet() (T, error) {
+	connection, _, err := pool.get(context.Background(), false)
+	return connection, err
+}
+
+func (pool *ConnectionPool[T]) get(ctx context.Context, wait bool) (T, AcquireInfo, error) {
+	var info AcquireInfo
+	connection, err := pool.getChain(func(ctx context.Context) (T, error) {
+		connection, acquireInfo, err := pool.acquire(ctx, wait)
+		info = acquireInfo
+		return pool.onGet(connection, err)
+	})(ctx)
+	return connection, info, opError("get", nil, err)
+}
 
-	for pool.connections.Len() == 0 && pool.numActiveConnections >= pool.MaxNumConnections {
-		pool.condition.Wait()
+// onGet calls OnGet if a connection was checked out, passing the result through. The caller must not hold the mutex.
+func (pool *ConnectionPool[T]) onGet(connection T, err error) (T, error) {
+	if err == nil && pool.OnGet != nil {
+		pool.OnGet(connection)
 	}
+	return connection, err
+}
 
+func (pool *ConnectionPool[T]) acquire(ctx context.Context, wait bool) (T, AcquireInfo, error) {
 	var zero T
+	var info AcquireInfo
 
-	if pool.connections.Len() > 0 {
-		element := pool.connections.Remove(pool.connections.Front())
-		connection, ok := element.(T)
+	pool.mutex.Lock()
+
+	if err := pool.unavailableErr(); err != nil {
+		pool.unlock()
+		return zero, info, err
+	}
+
+	// A getter may take a connection ahead of the waiting getters, as with a mutex, unless one of them is starving.
+	pool.pruneIdle(ctx)
+	if pool.numStarving == 0 && pool.connections.Len() > 0 {
+		connection := pool.popIdle()
+		pool.unlock()
+		info.Reused = true
+		return connection, info, nil
+	}
+
+	if pool.numStarving == 0 && pool.hasCapacity() && pool.creationLimitReached() {
+		pool.unlock()
+		return zero, info, motmedelErrors.NewWithTrace(connectionPoolErrors.ErrCreationLimitReached)
+	}
+
+	if pool.numStarving == 0 && pool.hasCapacity() {
+		pool.reserveCreation()
+		pool.unlock()
+	} else if !wait || pool.waitersFull() {
+		pool.unlock()
+		return zero, info, motmedelErrors.NewWithTrace(connectionPoolErrors.ErrPoolExhausted)
+	} else {
+		if !pool.saturated {
+			pool.saturated = true
+			if pool.OnSaturated != nil {
+				pool.OnSaturated()
+			}
+		}
+
+		w := newWaiter[T]()
+		pool.enqueueWaiter(ctx, w)
+		pool.unlock()
+
+		err := pool.waitToCompete(ctx, w)
+		info.WaitDuration = time.Since(w.waitingSince)
+
+		if err != nil {
+			return zero, info, err
+		}
+		if w.err != nil {
+			return zero, info, w.err
+		}
+		if w.hasConnection {
+			info.Reused = true
+			return w.connection, info, nil
+		}
+	}
+
+	dialStart := time.Now()
+	entry, err := pool.makeConnection(ctx, true)
+	info.DialDuration = time.Since(dialStart)
+	if err != nil {
+		return zero, info, err
+	}
+
+	return entry.conn, info, nil
+}
+
+// Do checks out a connection as GetContext does, calls fn with it and returns it to the pool with fn's error, which
+// is returned. If no connection can be checked out, the error is returned without calling fn.
+func (pool *ConnectionPool[T]) Do(ctx context.Context, fn func(T) error) error {
+	connection, err := pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = fn(connection)
+	pool.Put(ctx, connection, err)
+
+	return err
+}
+
+// DoWithRetry is like Do, but when fn fails, it retries with another connection after the delay that policy returns,
+// until fn succeeds, policy gives up or ctx is done. The connection fn failed with is discarded each time. The error
+// from the last attempt is returned, joined with ctx's error if ctx is done. A failure to check out a connection is
+// not retried.
+func (pool *ConnectionPool[T]) DoWithRetry(ctx context.Context, policy RetryPolicy, fn func(T) error) error {
+	for attempt := 1; ; attempt++ {
+		connection, err := pool.GetContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = fn(connection)
+		pool.Put(ctx, connection, err)
+		if err == nil || policy == nil {
+			return err
+		}
+
+		delay, ok := policy.NextDelay(attempt)
 		if !ok {
-			return zero, motmedelErrors.NewWithTrace(
-				fmt.Errorf("%w (generic io.Closer)", motmedelErrors.ErrConversionNotOk),
-				element,
-			)
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
 		}
-		if io.Closer(connection) == nil {
-			return zero, motmedelErrors.NewWithTrace(connectionPoolErrors.ErrNilConnection)
+	}
+}
+
+// GetN checks out n connections at once, waiting until the pool can provide all of them, so that getters needing
+// several connections never hold some while waiting for the rest. Either all n connections are returned or, on error,
+// none; connections already acquired are then returned to the pool. ErrPoolExhausted is returned if n exceeds the
+// maximum number of connections.
+func (pool *ConnectionPool[T]) GetN(ctx context.Context, n int) ([]T, error) {
+	connections, err := pool.getN(ctx, n)
+	return connections, opError("get", nil, err)
+}
+
+func (pool *ConnectionPool[T]) getN(ctx context.Context, n int) ([]T, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	pool.mutex.Lock()
+
+	if err := pool.unavailableErr(); err != nil {
+		pool.unlock()
+		return nil, err
+	}
+	if pool.MaxNumConnections > 0 && n > pool.MaxNumConnections {
+		pool.unlock()
+		return nil, motmedelErrors.NewWithTrace(connectionPoolErrors.ErrPoolExhausted, n)
+	}
+
+	w := newWaiter[T]()
+	w.batchSize = n
+	if pool.waiters.Len() == 0 && pool.batchAvailable(n) {
+		pool.serveBatch(w)
+		pool.unlock()
+	} else if pool.waitersFull() {
+		pool.unlock()
+		return nil, motmedelErrors.NewWithTrace(connectionPoolErrors.ErrPoolExhausted)
+	} else {
+		pool.enqueueWaiter(ctx, w)
+		pool.unlock()
+
+		if err := pool.wait(ctx, w); err != nil {
+			return nil, err
 		}
+	}
 
-		return connection, nil
+	connections := w.batch
+	err := w.err
+	numAttempted := 0
+	for ; err == nil && numAttempted < w.numReserved; numAttempted++ {
+		var entry *connEntry[T]
+		if entry, err = pool.makeConnection(ctx, true); err == nil {
+			connections = append(connections, entry.conn)
+		}
 	}
 
-	connection, err := pool.MakeConnection()
 	if err != nil {
-		return zero, fmt.Errorf("make connection: %w", err)
+		pool.mutex.Lock()
+		defer pool.unlock()
+
+		// A failed creation has already released its own slot.
+		pool.numActiveConnections -= w.numReserved - numAttempted
+		for _, connection := range connections {
+			if entry, ok := pool.checkIn(connection); ok {
+				pool.release(ctx, entry, nil)
+			}
+		}
+		pool.serveWaiters()
+
+		return nil, err
 	}
-	if io.Closer(connection) == nil {
-		return zero, motmedelErrors.NewWithTrace(connectionPoolErrors.ErrNilConnection)
+
+	for _, connection := range connections {
+		pool.onGet(connection, nil)
 	}
 
-	pool.numActiveConnections++
+	return connections, nil
+}
 
-	return connection, nil
+// GetExisting checks out an idle connection, waiting for one to be returned if necessary, but never creates a
+// connection. If the pool holds no connections at all, idle or checked out, ErrNoConnectionsAvailable is returned,
+// including when the last checked-out connection is discarded while waiting.
+func (pool *ConnectionPool[T]) GetExisting(ctx context.Context) (T, error) {
+	connection, err := pool.getExisting(ctx)
+	return connection, opError("get", nil, err)
 }
 
-func (pool *ConnectionPool[T]) Put(ctx context.Context, connection T, err error) {
-	if io.Closer(connection) == nil {
-		return
+func (pool *ConnectionPool[T]) getExisting(ctx context.Context) (T, error) {
+	var zero T
+
+	pool.mutex.Lock()
+
+	if err := pool.unavailableErr(); err != nil {
+		pool.unlock()
+		return zero, err
+	}
+
+	pool.pruneIdle(ctx)
+	if pool.connections.Len() > 0 {
+		connection := pool.popIdle()
+		pool.unlock()
+		return pool.onGet(connection, nil)
+	}
+
+	if pool.numActiveConnections == 0 {
+		pool.unlock()
+		return zero, motmedelErrors.NewWithTrace(connectionPoolErrors.ErrNoConnectionsAvailable)
+	}
+	if pool.waitersFull() {
+		pool.unlock()
+		return zero, motmedelErrors.NewWithTrace(connectionPoolErrors.ErrPoolExhausted)
+	}
+
+	w := newWaiter[T]()
+	w.existingOnly = true
+	pool.enqueueWaiter(ctx, w)
+	pool.unlock()
+
+	if err := pool.wait(ctx, w); err != nil {
+		return zero, err
+	}
+
+	if w.err != nil {
+		return zero, w.err
+	}
+
+	return pool.onGet(w.connection, nil)
+}
+
+// waitersFull reports whether MaxWaiters getters are already waiting. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) waitersFull() bool {
+	return pool.MaxWaiters > 0 && pool.waiters.Len() >= pool.MaxWaiters
+}
+
+// wait blocks until the waiter has been served or ctx is done, in which case the waiter is dequeued and the context's
+// error returned. A waiter served while the context is being cancelled keeps its result.
+func (pool *ConnectionPool[T]) wait(ctx context.Context, w *waiter[T]) error {
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	if w.served {
+		return nil
+	}
+	// A getter that gives up passes on its wake-up, or stops holding back the getters behind it, as a GetN getter
+	// does.
+	pool.dequeueWaiter(w)
+	pool.serveWaiters()
+	return ctx.Err()
+}
+
+// waitToCompete waits until a getter that accepts new connections has been served, competing for an idle connection,
+// or capacity to create one, each time it is woken. It becomes starving if it loses for longer than
+// starvationThreshold, which makes the pool hand it what it waits for instead.
+func (pool *ConnectionPool[T]) waitToCompete(ctx context.Context, w *waiter[T]) error {
+	for {
+		if err := pool.wait(ctx, w); err != nil {
+			return err
+		}
+
+		pool.mutex.Lock()
+		if w.served {
+			pool.unlock()
+			return nil
+		}
+
+		w.woken = false
+		pool.numWoken--
+		pool.pruneIdle(ctx)
+		switch {
+		case pool.connections.Len() > 0:
+			w.connection = pool.popIdle()
+			w.hasConnection = true
+		case pool.hasCapacity() && pool.creationLimitReached():
+			w.err = motmedelErrors.NewWithTrace(connectionPoolErrors.ErrCreationLimitReached)
+		case pool.hasCapacity():
+			pool.reserveCreation()
+		default:
+			if !w.starving && time.Since(w.waitingSince) > starvationThreshold {
+				w.starving = true
+				pool.numStarving++
+			}
+			pool.unlock()
+			continue
+		}
+
+		pool.dequeueWaiter(w)
+		pool.serveWaiters()
+		pool.unlock()
+		return nil
+	}
+}
+
+// GetPreferred checks out the idle connection matching the earliest of the preferences, trying each preference against
+// the idle connections in reuse order. If no idle connection matches, a fresh connection is created when the pool has
+// capacity for one; otherwise it behaves like Get. The preferences are called with the pool's mutex held and must not
+// call methods on the pool.
+func (pool *ConnectionPool[T]) GetPreferred(preferences ...func(T) bool) (T, error) {
+	pool.mutex.Lock()
+
+	if err := pool.unavailableErr(); err != nil {
+		pool.unlock()
+		var zero T
+		return zero, opError("get", nil, err)
+	}
+
+	for _, preference := range preferences {
+		for element := pool.connections.Front(); element != nil; {
+			next := element.Next()
+
+			entry := element.Value.(*connEntry[T])
+			if !preference(entry.conn) {
+				element = next
+				continue
+			}
+			if !pool.idleUsable(entry) {
+				pool.removeIdle(element)
+				pool.closeConnection(context.Background(), entry)
+				element = next
+				continue
+			}
+
+			pool.removeIdle(element)
+			pool.numActiveConnections++
+			pool.addCheckout(entry)
+			pool.unlock()
+
+			return pool.onGet(entry.conn, nil)
+		}
+	}
+
+	if pool.connections.Len() > 0 && pool.hasCapacity() && !pool.creationLimitReached() {
+		pool.reserveCreation()
+		pool.unlock()
+
+		entry, err := pool.makeConnection(context.Background(), true)
+		if err != nil {
+			var zero T
+			return zero, opError("get", nil, err)
+		}
+		return pool.onGet(entry.conn, nil)
+	}
+
+	pool.unlock()
+
+	return pool.Get()
+}
+
+// makeConnection creates a connection for a slot that the caller has already reserved, releasing the slot if the
+// creation fails, and returns its entry. The connection is recorded as checked out if checkOut is set.
+func (pool *ConnectionPool[T]) makeConnection(ctx context.Context, checkOut bool) (*connEntry[T], error) {
+	connection, err := pool.dial(ctx)
+	if err != nil {
+		err = fmt.Errorf("make connection: %w", err)
+	} else if io.Closer(connection) == nil {
+		err = motmedelErrors.NewWithTrace(connectionPoolErrors.ErrNilConnection)
 	}
 
 	pool.mutex.Lock()
-	defer pool.mutex.Unlock()
+	defer pool.unlock()
 
 	if err != nil {
-		if err := connection.Close(); err != nil && !motmedelErrors.IsClosedError(err) {
-			slog.WarnContext(
-				motmedelContext.WithErrorContextValue(
-					ctx,
-					motmedelErrors.NewWithTrace(fmt.Errorf("connection close: %w", err), connection),
+		pool.recordEvent(EventError, nil, err)
+		pool.numDialFailures++
+		pool.numErrors++
+		pool.numConnectionErrors.Add(1)
+		pool.numActiveConnections--
+		pool.serveWaiters()
+		return nil, err
+	}
+
+	pool.numDialFailures = 0
+	pool.numCreated++
+	pool.numConnectionsCreated.Add(1)
+	entry := &connEntry[T]{conn: connection, createdAt: time.Now(), generation: pool.generation}
+	pool.recordEvent(EventCreate, connection, nil)
+	if pool.closed {
+		// Close has already closed the connections it knew of; this one would never be closed.
+		pool.numActiveConnections--
+		pool.closeConnection(ctx, entry)
+		return nil, motmedelErrors.NewWithTrace(connectionPoolErrors.ErrPoolClosed)
+	}
+	if checkOut {
+		pool.addCheckout(entry)
+	}
+
+	return entry, nil
+}
+
+// dial calls MakeConnection, or MakeConnectionContext with ctx, retrying failures as RetryOnCreate allows until ctx is
+// done.
+func (pool *ConnectionPool[T]) dial(ctx context.Context) (T, error) {
+	var zero T
+
+	pool.mutex.Lock()
+	semaphore := pool.semaphore()
+	makeConnection := pool.MakeConnection
+	makeConnectionContext := pool.MakeConnectionContext
+	pool.unlock()
+
+	if makeConnectionContext != nil {
+		if makeConnection != nil {
+			return zero, motmedelErrors.NewWithTrace(
+				fmt.Errorf(
+					"%w: make connection and make connection context are both set",
+					connectionPoolErrors.ErrInvalidConfig,
 				),
-				"An error occurred when closing a connection.",
 			)
 		}
-		pool.numActiveConnections--
-	} else {
-		pool.connections.PushFront(connection)
+		makeConnection = func() (T, error) { return makeConnectionContext(ctx) }
 	}
 
-	pool.condition.Signal()
+	for attempt := 1; ; attempt++ {
+		if semaphore != nil {
+			select {
+			case semaphore <- struct{}{}:
+			case <-ctx.Done():
+				return zero, ctx.Err()
+			}
+		}
+
+		connection, err := callMakeConnection(makeConnection)
+		if semaphore != nil {
+			<-semaphore
+		}
+		if err == nil || pool.RetryOnCreate == nil {
+			return connection, err
+		}
+
+		delay, ok := pool.RetryOnCreate.NextDelay(attempt)
+		if !ok {
+			return connection, err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return connection, errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
 }
 
-func (pool *ConnectionPool[T]) Close() error {
-	pool.mutex.Lock()
-	defer pool.mutex.Unlock()
+// callMakeConnection calls fn, returning a panic in it as an error wrapping ErrMakeConnectionPanic, and the recovered
+// value too if it is an error, with the recovered value as input.
+func callMakeConnection[T io.Closer](fn func() (T, error)) (connection T, err error) {
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+
+		var zero T
+		connection = zero
+		if recoveredErr, ok := recovered.(error); ok {
+			err = fmt.Errorf("%w: %w", connectionPoolErrors.ErrMakeConnectionPanic, recoveredErr)
+		} else {
+			err = fmt.Errorf("%w: %v", connectionPoolErrors.ErrMakeConnectionPanic, recovered)
+		}
+		err = motmedelErrors.NewWithTrace(err, recovered)
+	}()
+
+	return fn()
+}
 
-	if pool.connections == nil || pool.connections.Len() == 0 {
+// semaphore returns the semaphore limiting concurrent creations to MaxConcurrentCreates, or nil if there is no limit.
+// A new semaphore replaces the old one when the limit changes. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) semaphore() chan struct{} {
+	if pool.MaxConcurrentCreates <= 0 {
 		return nil
 	}
-	for element := pool.connections.Front(); element != nil; {
-		connection, _ := element.Value.(io.Closer)
+	if cap(pool.createSemaphore) != pool.MaxConcurrentCreates {
+		pool.createSemaphore = make(chan struct{}, pool.MaxConcurrentCreates)
+	}
+	return pool.createSemaphore
+}
 
-		next := element.Next()
-		pool.connections.Remove(element)
+// popIdle checks out the next idle connection to be reused. The caller must hold the mutex and ensure that the idle
+// list is not empty.
+func (pool *ConnectionPool[T]) popIdle() T {
+	entry := pool.removeIdle(pool.connections.Front())
+	pool.numActiveConnections++
+	pool.addCheckout(entry)
 
-		if connection != nil {
-			if err := connection.Close(); err != nil {
-				return motmedelErrors.NewWithTrace(fmt.Errorf("connection close: %w", err), connection)
-			}
+	return entry.conn
+}
+
+// pruneIdle closes and discards unusable idle connections, from the next one to be reused, until the next one to be
+// reused, if any, is usable. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) pruneIdle(ctx context.Context) {
+	for pool.connections.Len() > 0 {
+		entry := pool.connections.Front().Value.(*connEntry[T])
+		if pool.idleUsable(entry) {
+			return
 		}
 
-		element = next
+		pool.removeIdle(pool.connections.Front())
+		pool.closeConnection(ctx, entry)
 	}
+}
 
-	pool.connections = list.New()
+// batchAvailable reports whether n connections can be handed out at once, as idle connections or capacity to create
+// new ones. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) batchAvailable(n int) bool {
+	numToCreate := max(n-pool.connections.Len(), 0)
+	return pool.MaxNumConnections <= 0 || pool.totalLen()+numToCreate <= pool.MaxNumConnections
+}
+
+// serveBatch checks out idle connections and reserves slots for a GetN waiter, for which batchAvailable must hold. The
+// caller must hold the mutex.
+func (pool *ConnectionPool[T]) serveBatch(w *waiter[T]) {
+	for range w.batchSize {
+		pool.pruneIdle(context.Background())
+
+		if pool.connections.Len() > 0 {
+			w.batch = append(w.batch, pool.popIdle())
+			continue
+		}
+
+		if pool.creationLimitReached() {
+			w.err = motmedelErrors.NewWithTrace(connectionPoolErrors.ErrCreationLimitReached)
+			return
+		}
+		pool.reserveCreation()
+		w.numReserved++
+	}
+}
+
+// failWaiters dequeues every waiting getter with err. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) failWaiters(err error) {
+	for element := pool.waiters.Front(); element != nil; element = pool.waiters.Front() {
+		w := element.Value.(*waiter[T])
+		w.err = err
+		pool.serveWaiter(w)
+	}
+}
 
-	pool.numActiveConnections = 0
+// serveWaiter dequeues a getter that has been served and signals it. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) serveWaiter(w *waiter[T]) {
+	pool.dequeueWaiter(w)
+	w.served = true
+	w.signal()
+}
+
+// dequeueWaiter removes a getter from the waiters. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) dequeueWaiter(w *waiter[T]) {
+	pool.waiters.Remove(w.element)
+	if w.woken {
+		w.woken = false
+		pool.numWoken--
+	}
+	if w.starving {
+		pool.numStarving--
+	}
+}
 
+// unavailableErr returns the error with which getters are turned away, or nil if the pool accepts getters. The caller
+// must hold the mutex.
+func (pool *ConnectionPool[T]) unavailableErr() error {
+	if pool.closed {
+		return motmedelErrors.NewWithTrace(connectionPoolErrors.ErrPoolClosed)
+	}
+	if pool.draining {
+		return motmedelErrors.NewWithTrace(connectionPoolErrors.ErrPoolDraining)
+	}
+	if pool.untracked() {
+		return motmedelErrors.NewWithTrace(
+			fmt.Errorf("%w: equal is required for connections that are not comparable", connectionPoolErrors.ErrInvalidConfig),
+		)
+	}
 	return nil
 }
 
+// untracked reports whether the pool cannot tell connections apart, because they are not comparable and Equal is not
+// set. Such a pool hands out no connections, as it could not track them.
+func (pool *ConnectionPool[T]) untracked() bool {
+	return pool.uncomparable && pool.Equal == nil
+}
+
+// idleUsable reports whether an idle connection may be checked out, i.e. that it has been neither idle longer than
+// MaxConnectionIdleTime nor alive longer than MaxConnectionLifetime, and that ValidateConnection accepts it. The caller
+// must hold the mutex.
+func (pool *ConnectionPool[T]) idleUsable(entry *connEntry[T]) bool {
+	if pool.MaxConnectionIdleTime > 0 && time.Since(entry.lastIdledAt) > pool.MaxConnectionIdleTime {
+		return false
+	}
+	// The creation time of a connection that the pool did not create is unknown.
+	if pool.MaxConnectionLifetime > 0 && !entry.createdAt.IsZero() &&
+		time.Since(entry.createdAt) > pool.MaxConnectionLifetime {
+		return false
+	}
+
+	return pool.ValidateConnection == nil || pool.ValidateConnection(entry.conn)
+}
+
+// serveWaiters lets waiting getters, in the order they started waiting, make use of idle connections or capacity to
+// create new ones. Getters that only accept existing connections, GetN getters and starving getters are handed what
+// they wait for directly. Other getters are woken to compete for it, as many as can be served, which spares a getter
+// that returns a connection and gets one again a wait. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) serveWaiters() {
+	if err := pool.unavailableErr(); err != nil {
+		pool.failWaiters(err)
+		if pool.numActiveConnections == 0 && pool.drained != nil {
+			close(pool.drained)
+			pool.drained = nil
+		}
+		return
+	}
+
+	for element := pool.waiters.Front(); element != nil; {
+		next := element.Next()
+		w := element.Value.(*waiter[T])
+
+		pool.pruneIdle(context.Background())
+		switch {
+		case w.batchSize > 0 && pool.MaxNumConnections > 0 && w.batchSize > pool.MaxNumConnections:
+			// The maximum has been lowered below what the getter wants since it started waiting; it can never be served.
+			w.err = motmedelErrors.NewWithTrace(connectionPoolErrors.ErrPoolExhausted, w.batchSize)
+		case w.batchSize > 0 && !pool.batchAvailable(w.batchSize):
+			return
+		case w.batchSize > 0:
+			pool.serveBatch(w)
+		case !w.existingOnly && !w.starving && w.woken:
+			element = next
+			continue
+		case !w.existingOnly && !w.starving && pool.numWoken < pool.numAvailable():
+			w.woken = true
+			pool.numWoken++
+			w.signal()
+			element = next
+			continue
+		case !w.existingOnly && !w.starving:
+			return
+		case pool.connections.Len() > 0:
+			w.connection = pool.popIdle()
+			w.hasConnection = true
+		case w.existingOnly && pool.numActiveConnections == 0:
+			w.err = motmedelErrors.NewWithTrace(connectionPoolErrors.ErrNoConnectionsAvailable)
+		case w.existingOnly:
+			// Capacity is of no use to a getter that only accepts existing connections.
+			element = next
+			continue
+		case pool.hasCapacity() && pool.creationLimitReached():
+			w.err = motmedelErrors.NewWithTrace(connectionPoolErrors.ErrCreationLimitReached)
+		case pool.hasCapacity():
+			pool.reserveCreation()
+		default:
+			return
+		}
+
+		pool.serveWaiter(w)
+		element = next
+	}
+
+	// Woken getters have yet to take what is available, so the pool is only unsaturated once none is left waiting.
+	if pool.saturated && pool.waiters.Len() == 0 && (pool.connections.Len() > 0 || pool.hasCapacity()) {
+		pool.saturated = false
+		if pool.OnUnsaturated != nil {
+			pool.OnUnsaturated()
+		}
+	}
+}
+
+func (pool *ConnectionPool[T]) Put(ctx context.Context, connection T, err error) {
+	pool.putChain(pool.put)(ctx, connection, err)
+}
+
+func (pool *ConnectionPool[T]) put(ctx context.Context, connection T, err error) {
+	if io.Closer(connection) == nil || pool.isClosed() {
+		return
+	}
+
+	if err == nil && pool.ValidateOnPut != nil && !pool.ValidateOnPut(connection) {
+		err = motmedelErrors.NewWithTrace(
+			&connectionPoolErrors.ConnectionPoolError{
+				Op:   "put",
+				Conn: connection,
+				Err:  connectionPoolErrors.ErrConnectionRejected,
+			},
+			connection,
+		)
+	}
+	if pool.OnPut != nil {
+		pool.OnPut(connection, err)
+	}
+
+	pool.mutex.Lock()
+
+	pool.numPuts++
+	if err != nil {
+		pool.numErrors++
+		pool.numConnectionErrors.Add(1)
+	}
+	pool.recordEvent(EventPut, connection, err)
+
+	var checkedOutAt time.Time
+	if entry, ok := pool.checkIn(connection); ok {
+		checkedOutAt = entry.checkedOutAt
+		pool.release(ctx, entry, err)
+	}
+
+	pool.unlock()
+
+	if !checkedOutAt.IsZero() && pool.OnCheckoutDuration != nil {
+		pool.OnCheckoutDuration(connection, time.Since(checkedOutAt))
+	}
+}
+
+// PutNoContext is like Put, with a background context for logging.
+func (pool *ConnectionPool[T]) PutNoContext(connection T, err error) {
+	pool.Put(context.Background(), connection, err)
+}
+
+// Discard closes a checked-out connection and frees its slot without making it idle, for retiring a connection that
+// is healthy but should not be reused. Unlike Put with an error, a failure to close the connection is not logged.
+func (pool *ConnectionPool[T]) Discard(ctx context.Context, connection T) {
+	if io.Closer(connection) == nil {
+		return
+	}
+
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	entry, ok := pool.checkIn(connection)
+	if !ok {
+		return
+	}
+
+	_ = pool.discardConnection(entry)
+	pool.serveWaiters()
+	pool.startRefill()
+}
+
+// checkIn releases the active slot of a connection being returned and returns its entry, reporting whether the pool
+// should still handle the connection, which it should not if it has been reclaimed or the pool has been closed. A
+// connection that the pool did not hand out gets a new entry. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) checkIn(connection T) (*connEntry[T], bool) {
+	if pool.closed {
+		return nil, false
+	}
+	if pool.untracked() {
+		return &connEntry[T]{conn: connection, generation: pool.generation}, true
+	}
+	if _, ok := pool.reclaimed.get(pool.Equal, connection); ok {
+		return nil, false
+	}
+
+	entry, ok := pool.checkoutIndex.get(pool.Equal, connection)
+	if !ok {
+		return &connEntry[T]{conn: connection, generation: pool.generation}, true
+	}
+	pool.removeCheckout(entry)
+	pool.numActiveConnections--
+
+	return entry, true
+}
+
+// release makes a connection that no longer occupies an active slot idle, or closes it if err is set or the connection
+// may not be kept idle, and lets waiting getters and the background refill make use of the change. The caller must hold
+// the mutex.
+func (pool *ConnectionPool[T]) release(ctx context.Context, entry *connEntry[T], err error) {
+	if err != nil || pool.mustDiscard(entry) {
+		pool.closeConnection(ctx, entry)
+	} else {
+		pool.pushIdle(entry)
+	}
+
+	pool.serveWaiters()
+	pool.evictOverIdleMemory(ctx)
+	pool.startRefill()
+}
+
+// mustDiscard reports whether a connection that is not idle must be closed rather than made idle, because the pool is
+// closed or draining, the connection is outdated or used up, or the idle limits are reached. The caller must hold the
+// mutex.
+func (pool *ConnectionPool[T]) mustDiscard(entry *connEntry[T]) bool {
+	if pool.closed || pool.draining || entry.generation < pool.generation {
+		return true
+	}
+	if pool.MaxConnectionUses > 0 && entry.useCount >= pool.MaxConnectionUses {
+		return true
+	}
+
+	return pool.idleLimitReached(entry)
+}
+
+// idleLimitReached reports whether making a connection idle would exceed MaxIdleConnections or MaxIdlePerAddr. The
+// caller must hold the mutex.
+func (pool *ConnectionPool[T]) idleLimitReached(entry *connEntry[T]) bool {
+	if pool.MaxIdleConnections > 0 && pool.connections.Len() >= pool.MaxIdleConnections {
+		return true
+	}
+	if pool.MaxIdlePerAddr > 0 {
+		if addr, ok := remoteAddr(entry.conn); ok && pool.idlePerAddr[addr] >= pool.MaxIdlePerAddr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// startRefill starts the background refill if the pool holds fewer than MinNumConnections and no refill is running.
+// The caller must hold the mutex.
+func (pool *ConnectionPool[T]) startRefill() {
+	if pool.totalLen() < pool.MinNumConnections && !pool.refilling && !pool.closed && !pool.draining {
+		pool.refilling = true
+		go pool.refill()
+	}
+}
+
+// WarmUp creates connections until the pool holds MinNumConnections, idle or checked out, and makes them idle. If
+// creating a connection fails or ctx is done, the connections created by the call are closed and the error returned.
+func (pool *ConnectionPool[T]) WarmUp(ctx context.Context) error {
+	pool.mutex.Lock()
+	n := pool.MinNumConnections
+	pool.unlock()
+
+	return pool.warmUp(ctx, n)
+}
+
+// WarmUpAsync is like WarmUp, but creates connections in the background until the pool holds n connections, idle or
+// checked out. The returned channel receives the error if warming up fails and is closed when warming up ends.
+func (pool *ConnectionPool[T]) WarmUpAsync(ctx context.Context, n int) <-chan error {
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(errCh)
+		if err := pool.warmUp(ctx, n); err != nil {
+			errCh <- err
+		}
+	}()
+
+	return errCh
+}
+
+// warmUp creates connections until the pool holds n connections, idle or checked out, and makes them idle.
+func (pool *ConnectionPool[T]) warmUp(ctx context.Context, n int) error {
+	var entries []*connEntry[T]
+	var err error
+
+	for {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+
+		pool.mutex.Lock()
+		if err = pool.unavailableErr(); err != nil {
+			pool.unlock()
+			break
+		}
+		if pool.totalLen() >= n || !pool.hasCapacity() || pool.creationLimitReached() {
+			pool.unlock()
+			break
+		}
+		pool.reserveCreation()
+		pool.unlock()
+
+		var entry *connEntry[T]
+		if entry, err = pool.makeConnection(ctx, false); err != nil {
+			break
+		}
+		entries = append(entries, entry)
+	}
+
+	if err != nil {
+		pool.mutex.Lock()
+		defer pool.unlock()
+
+		for _, entry := range entries {
+			pool.numActiveConnections--
+			pool.closeConnection(ctx, entry)
+		}
+		pool.serveWaiters()
+
+		return opError("warm up", nil, err)
+	}
+
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	for _, entry := range entries {
+		pool.numActiveConnections--
+		pool.release(ctx, entry, nil)
+	}
+
+	return nil
+}
+
+// refill runs WarmUp in the background on behalf of Put, which has set refilling.
+func (pool *ConnectionPool[T]) refill() {
+	ctx := context.Background()
+
+	if err := pool.WarmUp(ctx); err != nil {
+		pool.logger().WarnContext(
+			motmedelContext.WithErrorContextValue(ctx, motmedelErrors.NewWithTrace(fmt.Errorf("warm up: %w", err))),
+			"An error occurred when refilling the pool to its minimum number of connections.",
+		)
+	}
+
+	pool.mutex.Lock()
+	pool.refilling = false
+	pool.unlock()
+}
+
+// evictOverIdleMemory closes the oldest idle connections while the estimated idle memory exceeds MaxIdleMemoryBytes.
+// The caller must hold the mutex.
+func (pool *ConnectionPool[T]) evictOverIdleMemory(ctx context.Context) {
+	if pool.MaxIdleMemoryBytes <= 0 || pool.PerConnectionBytes <= 0 {
+		return
+	}
+
+	for pool.connections.Len() > 0 && int64(pool.connections.Len())*pool.PerConnectionBytes > pool.MaxIdleMemoryBytes {
+		entry := pool.removeIdle(pool.oldestIdle())
+		pool.closeConnection(ctx, entry)
+	}
+}
+
+// hasCapacity reports whether the pool may hold another connection. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) hasCapacity() bool {
+	return pool.MaxNumConnections <= 0 || pool.totalLen() < pool.MaxNumConnections
+}
+
+// numAvailable returns the number of connections that can be checked out without waiting, as idle connections or
+// capacity to create new ones. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) numAvailable() int {
+	if pool.MaxNumConnections <= 0 {
+		return math.MaxInt
+	}
+	return pool.connections.Len() + max(pool.MaxNumConnections-pool.totalLen(), 0)
+}
+
+// totalLen returns the number of idle and active connections. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) totalLen() int {
+	return pool.connections.Len() + pool.numActiveConnections
+}
+
+func (pool *ConnectionPool[T]) creationLimitReached() bool {
+	return pool.MaxTotalCreations > 0 && pool.numCreations >= pool.MaxTotalCreations
+}
+
+// reserveCreation reserves a slot for a connection that is about to be created. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) reserveCreation() {
+	pool.numActiveConnections++
+	pool.numCreations++
+}
+
+// SetMakeConnection replaces the function that creates connections, e.g. when credentials rotate, clearing
+// MakeConnectionContext. Idle and checked-out connections are unaffected; connections created from now on are created
+// with fn.
+func (pool *ConnectionPool[T]) SetMakeConnection(fn func() (T, error)) {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	pool.MakeConnection = fn
+	pool.MakeConnectionContext = nil
+}
+
+// RollConnections replaces the pool's connections with fresh ones created by the current MakeConnection, e.g. after
+// SetMakeConnection when credentials rotate. The idle connections are replaced one at a time, so that the pool keeps
+// serving getters meanwhile, and connections checked out at the time of the call are closed rather than made idle
+// when they are returned. It returns once every idle connection has been replaced, or with an error if creating a
+// connection fails or ctx is done.
+func (pool *ConnectionPool[T]) RollConnections(ctx context.Context) error {
+	return opError("roll connections", nil, pool.rollConnections(ctx))
+}
+
+func (pool *ConnectionPool[T]) rollConnections(ctx context.Context) error {
+	pool.mutex.Lock()
+	pool.generation++
+	generation := pool.generation
+	pool.unlock()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		pool.mutex.Lock()
+		if err := pool.unavailableErr(); err != nil {
+			pool.unlock()
+			return err
+		}
+
+		var element *list.Element
+		for e := pool.connections.Front(); e != nil; e = e.Next() {
+			if e.Value.(*connEntry[T]).generation < generation {
+				element = e
+				break
+			}
+		}
+		if element == nil {
+			pool.unlock()
+			return nil
+		}
+		if pool.creationLimitReached() {
+			pool.unlock()
+			return motmedelErrors.NewWithTrace(connectionPoolErrors.ErrCreationLimitReached)
+		}
+
+		// The old connection's slot is reserved for its replacement.
+		pool.closeConnection(ctx, pool.removeIdle(element))
+		pool.reserveCreation()
+		pool.unlock()
+
+		entry, err := pool.makeConnection(ctx, false)
+		if err != nil {
+			return err
+		}
+
+		pool.mutex.Lock()
+		pool.numActiveConnections--
+		pool.release(ctx, entry, nil)
+		pool.unlock()
+	}
+}
+
+// MaxConnections returns the maximum number of connections held by the pool.
+func (pool *ConnectionPool[T]) MaxConnections() int {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	return pool.MaxNumConnections
+}
+
+// SetMaxConnections sets the maximum number of connections held by the pool. Capacity made available by raising the
+// limit is handed to waiting getters in the order they started waiting. Waiting GetN getters that want more
+// connections than the new limit fail with ErrPoolExhausted.
+func (pool *ConnectionPool[T]) SetMaxConnections(n int) {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	pool.MaxNumConnections = n
+	pool.serveWaiters()
+}
+
+// Resize sets the maximum number of connections held by the pool. When shrinking, idle connections are closed, oldest
+// first, until the pool fits within the new maximum; checked-out connections are left to be returned. Errors from
+// closing connections are joined and returned. Capacity made available by growing is handed to waiting getters, and
+// waiting GetN getters that want more connections than the new maximum fail with ErrPoolExhausted.
+func (pool *ConnectionPool[T]) Resize(newMax int) error {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	return errors.Join(pool.resize(newMax)...)
+}
+
+// resize implements Resize, returning the errors from closing connections. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) resize(newMax int) []error {
+	var errs []error
+	for newMax > 0 && pool.totalLen() > newMax && pool.connections.Len() > 0 {
+		entry := pool.removeIdle(pool.oldestIdle())
+		if err := pool.discardConnection(entry); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	pool.MaxNumConnections = newMax
+	pool.serveWaiters()
+
+	return errs
+}
+
+// SetIdleDeadlines sets the deadline of every idle connection that has a SetDeadline method, such as a net.Conn, to d
+// from now, e.g. after a network partition so that stale connections fail their next health check or use rather than
+// hang. Connections without the method are left alone. Errors from setting deadlines are joined and returned.
+func (pool *ConnectionPool[T]) SetIdleDeadlines(d time.Duration) error {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	deadline := time.Now().Add(d)
+
+	var errs []error
+	for element := pool.connections.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*connEntry[T])
+		connection := entry.conn
+		deadlineConnection, ok := any(connection).(interface{ SetDeadline(time.Time) error })
+		if !ok {
+			continue
+		}
+
+		if err := deadlineConnection.SetDeadline(deadline); err != nil {
+			errs = append(
+				errs,
+				motmedelErrors.NewWithTrace(
+					&connectionPoolErrors.ConnectionPoolError{Op: "set deadline", Conn: connection, Err: err},
+					pool.errorInput(entry)...,
+				),
+			)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// TrimToFDBudget closes idle connections, oldest first, until the number of connections held by the pool (idle and
+// checked out) fits within maxFDs. Checked-out connections cannot be reclaimed, so the pool may remain above the
+// budget if too few connections are idle. The number of closed connections is returned.
+func (pool *ConnectionPool[T]) TrimToFDBudget(maxFDs int) int {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	numClosed := 0
+	for pool.totalLen() > maxFDs && pool.connections.Len() > 0 {
+		entry := pool.removeIdle(pool.oldestIdle())
+		numClosed++
+		pool.closeConnection(context.Background(), entry)
+	}
+
+	return numClosed
+}
+
+// Transfer moves up to n idle connections from the pool to dst, limited by the capacity available in dst and by its
+// MaxIdleConnections and MaxIdlePerAddr, and returns the number of connections moved. Both pools' accounting is
+// adjusted so the connections count against dst only. Nothing is moved if dst is closed or draining; connections that
+// dst cannot take are left in the pool.
+func (pool *ConnectionPool[T]) Transfer(dst *ConnectionPool[T], n int) int {
+	if dst == nil || dst == pool || n <= 0 {
+		return 0
+	}
+
+	// Reserve capacity in the destination first so that the connections rarely need to be handed back.
+	dst.mutex.Lock()
+	if dst.unavailableErr() != nil {
+		dst.unlock()
+		return 0
+	}
+	numReserved := n
+	if dst.MaxNumConnections > 0 {
+		numReserved = min(numReserved, max(dst.MaxNumConnections-dst.totalLen(), 0))
+	}
+	if dst.MaxIdleConnections > 0 {
+		numReserved = min(numReserved, max(dst.MaxIdleConnections-dst.connections.Len(), 0))
+	}
+	dst.numActiveConnections += numReserved
+	dst.unlock()
+
+	if numReserved == 0 {
+		return 0
+	}
+
+	pool.mutex.Lock()
+	var entries []*connEntry[T]
+	for len(entries) < numReserved && pool.connections.Len() > 0 {
+		entries = append(entries, pool.removeIdle(pool.connections.Front()))
+	}
+	pool.unlock()
+
+	// The destination may have been closed, or have had connections made idle, since the capacity was reserved.
+	dst.mutex.Lock()
+	dst.numActiveConnections -= numReserved
+	var rejected []*connEntry[T]
+	numMoved := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if dst.unavailableErr() != nil || dst.idleLimitReached(entry) {
+			rejected = append(rejected, entry)
+			continue
+		}
+		entry.generation = dst.generation
+		dst.pushIdle(entry)
+		numMoved++
+	}
+	dst.serveWaiters()
+	dst.unlock()
+
+	if len(rejected) > 0 {
+		pool.mutex.Lock()
+		for _, entry := range rejected {
+			if pool.mustDiscard(entry) {
+				pool.closeConnection(context.Background(), entry)
+			} else {
+				pool.restoreIdle(entry, entry.lastIdledAt)
+			}
+		}
+		pool.serveWaiters()
+		pool.unlock()
+	}
+
+	return numMoved
+}
+
+// maxReclaimed caps the number of reclaimed connections that the pool remembers in order to ignore a late Put of them.
+// A leaked connection may never be returned, so the oldest are forgotten beyond the cap.
+const maxReclaimed = 1024
+
+// ScanCheckouts logs a warning for each connection that has been checked out longer than MaxCheckoutDuration. If
+// ReclaimLeakedConnections is set, such connections are also closed and their slots freed; a later Put of one of the
+// most recently reclaimed connections is ignored until the pool is reset. The number of leaked connections found is
+// returned.
+func (pool *ConnectionPool[T]) ScanCheckouts(ctx context.Context) int {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	if pool.MaxCheckoutDuration <= 0 {
+		return 0
+	}
+
+	numLeaked := 0
+	// Iterating backwards lets removeCheckout move the last checkout into the position of a reclaimed one.
+	for i := len(pool.checkouts) - 1; i >= 0; i-- {
+		checkout := pool.checkouts[i]
+		if time.Since(checkout.checkedOutAt) <= pool.MaxCheckoutDuration {
+			continue
+		}
+		numLeaked++
+
+		connection := checkout.conn
+		pool.logger().WarnContext(
+			motmedelContext.WithErrorContextValue(
+				ctx,
+				motmedelErrors.NewWithTrace(
+					&connectionPoolErrors.ConnectionPoolError{
+						Op:   "scan checkouts",
+						Conn: connection,
+						Err:  connectionPoolErrors.ErrMaxCheckoutDurationExceeded,
+					},
+					pool.errorInput(checkout)...,
+				),
+			),
+			"A connection has been checked out longer than the maximum checkout duration.",
+		)
+
+		if !pool.ReclaimLeakedConnections {
+			continue
+		}
+
+		pool.removeCheckout(checkout)
+		if len(pool.reclaimedOrder) >= maxReclaimed {
+			pool.reclaimed.delete(pool.Equal, pool.reclaimedOrder[0])
+			pool.reclaimedOrder = slices.Delete(pool.reclaimedOrder, 0, 1)
+		}
+		pool.reclaimed.set(pool.Equal, connection, struct{}{})
+		pool.reclaimedOrder = append(pool.reclaimedOrder, connection)
+		pool.closeConnection(ctx, checkout)
+		pool.numActiveConnections--
+	}
+
+	pool.serveWaiters()
+
+	return numLeaked
+}
+
+// CheckLeaks returns the records of the connections that have been checked out longer than MaxCheckoutDuration, or of
+// all checked-out connections if MaxCheckoutDuration is zero. Only checkouts made while LeakDetection is enabled are
+// recorded.
+func (pool *ConnectionPool[T]) CheckLeaks() []LeakRecord[T] {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	var leaks []LeakRecord[T]
+	for _, entry := range pool.checkouts {
+		record := entry.leakRecord
+		if record != nil && time.Since(record.CheckedOutAt) > pool.MaxCheckoutDuration {
+			leaks = append(leaks, *record)
+		}
+	}
+
+	return leaks
+}
+
+// MonitorCheckouts runs ScanCheckouts every interval until ctx is done.
+func (pool *ConnectionPool[T]) MonitorCheckouts(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pool.ScanCheckouts(ctx)
+		}
+	}
+}
+
+// Close closes the idle and the checked-out connections and closes the pool, which makes the pool an io.Closer.
+// Getters, including those already waiting, are turned away with ErrPoolClosed, a connection whose creation completes
+// after Close is closed, and returning a connection after Close does nothing. Errors from closing connections are
+// joined and returned. Closing a closed pool does nothing and returns nil.
+func (pool *ConnectionPool[T]) Close() error {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	if pool.closed {
+		return nil
+	}
+
+	pool.closed = true
+	close(pool.done)
+	pool.serveWaiters()
+
+	return errors.Join(append(pool.closeIdle(), pool.closeCheckouts()...)...)
+}
+
+// Reset closes the idle connections and zeroes the counters reported by Stats and Degraded, leaving the pool open.
+// Checked-out connections are unaffected, but the pool forgets the connections reclaimed by ScanCheckouts. Errors from
+// closing connections are joined and returned.
+func (pool *ConnectionPool[T]) Reset() error {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	err := errors.Join(pool.closeIdle()...)
+
+	pool.numGets = 0
+	pool.numPuts = 0
+	pool.numCreated = 0
+	pool.numClosed = 0
+	pool.numErrors = 0
+	pool.numDialFailures = 0
+	pool.forgetReclaimed()
+
+	pool.serveWaiters()
+	pool.startRefill()
+
+	return err
+}
+
+// closeIdle closes every idle connection, returning the errors from closing them. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) closeIdle() []error {
+	if pool.connections.Len() == 0 {
+		return nil
+	}
+
+	var errs []error
+	for element := pool.connections.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*connEntry[T])
+		entry.idleElement = nil
+		if err := pool.discardConnection(entry); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	pool.connections = list.New()
+	clear(pool.idlePerAddr)
+
+	return errs
+}
+
+// closeCheckouts closes every checked-out connection, returning the errors from closing them. The caller must hold the
+// mutex.
+func (pool *ConnectionPool[T]) closeCheckouts() []error {
+	var errs []error
+	for _, entry := range pool.checkouts {
+		entry.leakRecord = nil
+		if err := pool.discardConnection(entry); err != nil {
+			errs = append(errs, err)
+		}
+		pool.numActiveConnections--
+	}
+
+	pool.checkouts = nil
+	pool.checkoutIndex.clear()
+	pool.forgetReclaimed()
+
+	return errs
+}
+
+// Drain shuts the pool down gracefully. Getters are turned away with ErrPoolDraining from the start, including those
+// already waiting, and connections returned while draining are closed. Once no connection is checked out or being
+// created, or ctx is done, the idle connections are closed as by Close. The pool cannot be used after Drain. If ctx is
+// done first, its error is returned and the connections still checked out are closed as well.
+func (pool *ConnectionPool[T]) Drain(ctx context.Context) error {
+	pool.mutex.Lock()
+	pool.draining = true
+	pool.serveWaiters()
+
+	var drained chan struct{}
+	if pool.numActiveConnections > 0 {
+		if pool.drained == nil {
+			pool.drained = make(chan struct{})
+		}
+		drained = pool.drained
+	}
+	pool.unlock()
+
+	var err error
+	if drained != nil {
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	return errors.Join(err, pool.Close())
+}
+
+// runHealthChecks runs checkIdleHealth every IdleHealthCheckInterval until the pool is closed.
+func (pool *ConnectionPool[T]) runHealthChecks() {
+	ticker := time.NewTicker(pool.IdleHealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pool.done:
+			return
+		case <-ticker.C:
+			pool.checkIdleHealth(context.Background())
+		}
+	}
+}
+
+// runIdleReaper runs reapIdle every interval until the pool is closed.
+func (pool *ConnectionPool[T]) runIdleReaper(interval time.Duration) {
+	ticker := time.NewTicker(max(interval, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pool.done:
+			return
+		case <-ticker.C:
+			pool.reapIdle(context.Background())
+		}
+	}
+}
+
+// reapIdle closes and discards the idle connections that have been idle longer than MaxConnectionIdleTime, walking the
+// idle list from the connection that has been idle the longest until it reaches one that has not expired.
+func (pool *ConnectionPool[T]) reapIdle(ctx context.Context) {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	if pool.MaxConnectionIdleTime <= 0 {
+		return
+	}
+
+	for pool.connections.Len() > 0 {
+		element := pool.oldestIdle()
+		entry := element.Value.(*connEntry[T])
+		if time.Since(entry.lastIdledAt) <= pool.MaxConnectionIdleTime {
+			break
+		}
+
+		pool.removeIdle(element)
+		pool.closeConnection(ctx, entry)
+	}
+
+	pool.startRefill()
+}
+
+// checkIdleHealth runs IdleHealthCheck on the idle connections, one at a time, oldest first. Each connection is taken
+// off the idle list and counted as active while being checked, so that neither it nor its slot is handed out
+// meanwhile, and is closed if it fails the check or made idle again with its idle time kept. The sweep stops early
+// while getters are waiting, so that it does not hold back connections they could be served; the next sweep checks
+// the remaining connections.
+func (pool *ConnectionPool[T]) checkIdleHealth(ctx context.Context) {
+	pool.mutex.Lock()
+	var pending []*connEntry[T]
+	for element := pool.oldestIdle(); element != nil; element = pool.newerIdle(element) {
+		pending = append(pending, element.Value.(*connEntry[T]))
+	}
+	pool.unlock()
+
+	for _, entry := range pending {
+		pool.mutex.Lock()
+		if pool.closed || pool.waiters.Len() > 0 {
+			pool.unlock()
+			return
+		}
+		// The connection may have been checked out, or closed, since the sweep started.
+		if entry.idleElement == nil {
+			pool.unlock()
+			continue
+		}
+		idleSince := entry.lastIdledAt
+		pool.removeIdle(entry.idleElement)
+		pool.numActiveConnections++
+		pool.unlock()
+
+		healthy := pool.IdleHealthCheck(entry.conn)
+
+		pool.mutex.Lock()
+		pool.numActiveConnections--
+		if !healthy || pool.mustDiscard(entry) {
+			pool.closeConnection(ctx, entry)
+		} else {
+			pool.restoreIdle(entry, idleSince)
+		}
+		pool.serveWaiters()
+		pool.startRefill()
+		pool.unlock()
+	}
+}
+
+// Degraded reports whether the pool is struggling to serve connections, as opposed to merely being saturated. The pool
+// is degraded while the number of consecutive failures to create a connection is at least DegradedAfterDialFailures.
+func (pool *ConnectionPool[T]) Degraded() bool {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	return pool.DegradedAfterDialFailures > 0 && pool.numDialFailures >= pool.DegradedAfterDialFailures
+}
+
+// IsHealthy reports whether the pool can currently serve a connection: it is open and not draining, and it either has
+// an idle connection or may create one.
+func (pool *ConnectionPool[T]) IsHealthy() bool {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	if pool.unavailableErr() != nil {
+		return false
+	}
+
+	return pool.connections.Len() > 0 || (pool.hasCapacity() && !pool.creationLimitReached())
+}
+
+// Len returns the number of idle connections.
+//
+// Deprecated: Len is easily mistaken for the total number of connections; use IdleLen, ActiveLen or TotalLen instead.
 func (pool *ConnectionPool[T]) Len() int {
+	return pool.IdleLen()
+}
+
+// IdleLen returns the number of idle connections.
+func (pool *ConnectionPool[T]) IdleLen() int {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
 	return pool.connections.Len()
-}
\ No newline at end of file
+}
+
+// OldestIdleConnection returns the creation time of the idle connection created the longest ago, and false if the pool
+// holds no idle connection of known creation time. Monitoring it against MaxConnectionLifetime shows whether
+// connections are being replaced.
+func (pool *ConnectionPool[T]) OldestIdleConnection() (time.Time, bool) {
+	return pool.idleCreatedAt(func(a, b time.Time) bool { return a.Before(b) })
+}
+
+// NewestIdleConnection returns the creation time of the idle connection created most recently, and false if the pool
+// holds no idle connection of known creation time.
+func (pool *ConnectionPool[T]) NewestIdleConnection() (time.Time, bool) {
+	return pool.idleCreatedAt(func(a, b time.Time) bool { return a.After(b) })
+}
+
+// idleCreatedAt returns the creation time of the idle connection that precedes all others according to less. The
+// idle list is ordered by when the connections became idle, not by when they were created, so all of it is searched.
+func (pool *ConnectionPool[T]) idleCreatedAt(less func(a, b time.Time) bool) (time.Time, bool) {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	var createdAt time.Time
+	for element := pool.connections.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*connEntry[T])
+		if entry.createdAt.IsZero() {
+			continue
+		}
+		if createdAt.IsZero() || less(entry.createdAt, createdAt) {
+			createdAt = entry.createdAt
+		}
+	}
+
+	return createdAt, !createdAt.IsZero()
+}
+
+// ActiveLen returns the number of connections that are checked out or being created.
+func (pool *ConnectionPool[T]) ActiveLen() int {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	return pool.numActiveConnections
+}
+
+// TotalLen returns the number of connections held by the pool, idle and active.
+func (pool *ConnectionPool[T]) TotalLen() int {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	return pool.totalLen()
+}
+
+// WaitingLen returns the number of getters waiting for a connection or for capacity to create one.
+func (pool *ConnectionPool[T]) WaitingLen() int {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	return pool.waiters.Len()
+}
+
+// Tag attaches an identifier to a connection held by the pool, checked out or idle, that is included in the log
+// messages about it for the rest of its lifetime, so that a specific connection can be traced across log lines. The
+// tag stays with the connection if it is transferred to another pool.
+func (pool *ConnectionPool[T]) Tag(connection T, tag string) {
+	if io.Closer(connection) == nil {
+		return
+	}
+
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	if pool.untracked() {
+		return
+	}
+	if entry, ok := pool.checkoutIndex.get(pool.Equal, connection); ok {
+		entry.tag = tag
+		return
+	}
+	for element := pool.connections.Front(); element != nil; element = element.Next() {
+		if entry := element.Value.(*connEntry[T]); pool.equal(entry.conn, connection) {
+			entry.tag = tag
+			return
+		}
+	}
+}
+
+func (pool *ConnectionPool[T]) equal(a, b T) bool {
+	if pool.Equal != nil {
+		return pool.Equal(a, b)
+	}
+	return any(a) == any(b)
+}
+
+// addCheckout records that a connection has been checked out. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) addCheckout(entry *connEntry[T]) {
+	pool.numGets++
+	pool.recordEvent(EventGet, entry.conn, nil)
+	entry.useCount++
+	entry.checkedOutAt = time.Now()
+	entry.checkoutPosition = len(pool.checkouts)
+	pool.checkouts = append(pool.checkouts, entry)
+	pool.checkoutIndex.set(pool.Equal, entry.conn, entry)
+	if pool.LeakDetection {
+		entry.leakRecord = &LeakRecord[T]{Connection: entry.conn, CheckedOutAt: entry.checkedOutAt, Stack: debug.Stack()}
+	}
+}
+
+// removeCheckout removes a checked-out connection's entry from checkouts, moving the last checkout into its position,
+// along with its leak record. It does not release the connection's active slot. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) removeCheckout(entry *connEntry[T]) {
+	last := pool.checkouts[len(pool.checkouts)-1]
+	last.checkoutPosition = entry.checkoutPosition
+	pool.checkouts[entry.checkoutPosition] = last
+	pool.checkouts[len(pool.checkouts)-1] = nil
+	pool.checkouts = pool.checkouts[:len(pool.checkouts)-1]
+
+	pool.checkoutIndex.delete(pool.Equal, entry.conn)
+	entry.leakRecord = nil
+}
+
+// forgetReclaimed forgets the connections reclaimed by ScanCheckouts. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) forgetReclaimed() {
+	pool.reclaimed.clear()
+	pool.reclaimedOrder = nil
+}
+
+// pushIdle adds a connection to the idle list, at the front in LIFO order and at the back in FIFO order, so that the
+// next connection to be reused is always at the front. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) pushIdle(entry *connEntry[T]) {
+	entry.lastIdledAt = time.Now()
+	if pool.Order == OrderFIFO {
+		entry.idleElement = pool.connections.PushBack(entry)
+	} else {
+		entry.idleElement = pool.connections.PushFront(entry)
+	}
+	if addr, ok := remoteAddr(entry.conn); ok {
+		pool.idlePerAddr[addr]++
+	}
+}
+
+// oldestIdle returns the element of the connection that has been idle the longest. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) oldestIdle() *list.Element {
+	if pool.Order == OrderFIFO {
+		return pool.connections.Front()
+	}
+	return pool.connections.Back()
+}
+
+// newerIdle returns the element of the connection that has been idle next longest after that of element, or nil if
+// there is none. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) newerIdle(element *list.Element) *list.Element {
+	if pool.Order == OrderFIFO {
+		return element.Next()
+	}
+	return element.Prev()
+}
+
+// restoreIdle makes a connection idle again as if it had been idle since idleSince, inserting it among the idle
+// connections by idle time so that the idle list stays ordered. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) restoreIdle(entry *connEntry[T], idleSince time.Time) {
+	entry.lastIdledAt = idleSince
+
+	element := pool.oldestIdle()
+	for element != nil && !element.Value.(*connEntry[T]).lastIdledAt.After(idleSince) {
+		element = pool.newerIdle(element)
+	}
+	switch {
+	case element == nil && pool.Order == OrderFIFO:
+		entry.idleElement = pool.connections.PushBack(entry)
+	case element == nil:
+		entry.idleElement = pool.connections.PushFront(entry)
+	case pool.Order == OrderFIFO:
+		entry.idleElement = pool.connections.InsertBefore(entry, element)
+	default:
+		entry.idleElement = pool.connections.InsertAfter(entry, element)
+	}
+	if addr, ok := remoteAddr(entry.conn); ok {
+		pool.idlePerAddr[addr]++
+	}
+}
+
+// removeIdle removes an element from the idle list and returns its entry. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) removeIdle(element *list.Element) *connEntry[T] {
+	entry := pool.connections.Remove(element).(*connEntry[T])
+	entry.idleElement = nil
+	if addr, ok := remoteAddr(entry.conn); ok {
+		if pool.idlePerAddr[addr]--; pool.idlePerAddr[addr] <= 0 {
+			delete(pool.idlePerAddr, addr)
+		}
+	}
+	return entry
+}
+
+// opError wraps err in a ConnectionPoolError for the operation, or returns nil if err is nil.
+func opError(op string, connection any, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &connectionPoolErrors.ConnectionPoolError{Op: op, Conn: connection, Err: err}
+}
+
+func remoteAddr(connection any) (string, bool) {
+	addrConnection, ok := connection.(interface{ RemoteAddr() net.Addr })
+	if !ok {
+		return "", false
+	}
+
+	addr := addrConnection.RemoteAddr()
+	if addr == nil {
+		return "", false
+	}
+
+	return addr.String(), true
+}
+
+// logger returns Logger, or the default logger if it is nil.
+func (pool *ConnectionPool[T]) logger() *slog.Logger {
+	if pool.Logger != nil {
+		return pool.Logger
+	}
+	return slog.Default()
+}
+
+// errorInput returns the input to attach to an error concerning the entry's connection. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) errorInput(entry *connEntry[T]) []any {
+	if entry.tag != "" {
+		return []any{entry.conn, entry.tag}
+	}
+	return []any{entry.conn}
+}
+
+// closeConnection closes a connection that has been removed from the pool, logging any error. The caller must hold
+// the mutex.
+func (pool *ConnectionPool[T]) closeConnection(ctx context.Context, entry *connEntry[T]) {
+	if err := pool.discardConnection(entry); err != nil && !motmedelErrors.IsClosedError(err) {
+		pool.logger().WarnContext(
+			motmedelContext.WithErrorContextValue(ctx, err),
+			"An error occurred when closing a connection.",
+		)
+	}
+}
+
+// discardConnection closes a connection that has been removed from the pool, returning any error from closing it. The
+// caller must hold the mutex.
+func (pool *ConnectionPool[T]) discardConnection(entry *connEntry[T]) error {
+	connection := entry.conn
+
+	var err error
+	if closeErr := connection.Close(); closeErr != nil {
+		err = motmedelErrors.NewWithTrace(
+			&connectionPoolErrors.ConnectionPoolError{Op: "close", Conn: connection, Err: closeErr},
+			pool.errorInput(entry)...,
+		)
+	}
+
+	pool.numClosed++
+	pool.recordEvent(EventClose, connection, err)
+	if pool.OnClose != nil {
+		pool.closedConnections = append(pool.closedConnections, &closedConnection[T]{connection: connection, err: err})
+	}
+
+	return err
+}
+
+// isClosed reports whether the pool has been closed.
+func (pool *ConnectionPool[T]) isClosed() bool {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	return pool.closed
+}
+
+// unlock releases the mutex and then calls OnClose for the connections closed while it was held.
+func (pool *ConnectionPool[T]) unlock() {
+	closedConnections := pool.closedConnections
+	pool.closedConnections = nil
+	pool.mutex.Unlock()
+
+	for _, closed := range closedConnections {
+		pool.OnClose(closed.connection, closed.err)
+	}
+}
+
+// starvationThreshold is how long a getter may wait before the pool stops letting other getters take connections
+// ahead of it.
+const starvationThreshold = time.Millisecond
+
+func newWaiter[T io.Closer]() *waiter[T] {
+	return &waiter[T]{ready: make(chan struct{}, 1), waitingSince: time.Now()}
+}
+
+// signal wakes the getter, unless it has already been signalled and has yet to wake.
+func (w *waiter[T]) signal() {
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
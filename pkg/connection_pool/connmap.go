@@ -0,0 +1,79 @@
+package connection_pool
+
+import (
+	"io"
+	"slices"
+)
+
+// connMap maps connections to values. Without an Equal function, connections compare as interfaces and are used as
+// map keys, so that lookups do not grow with the number of connections. An Equal function cannot be used to hash
+// connections, so with one the pairs are scanned instead. Connections that are not comparable cannot be map keys, so
+// a pool without Equal only reads an empty map with them, which get and delete do without hashing the key.
+type connMap[T io.Closer, V any] struct {
+	byKey map[any]V
+	pairs []connPair[T, V]
+}
+
+type connPair[T io.Closer, V any] struct {
+	connection T
+	value      V
+}
+
+// get returns the connection's value, reporting whether it has one. equal is the pool's Equal function.
+func (m *connMap[T, V]) get(equal func(a, b T) bool, connection T) (V, bool) {
+	if equal == nil {
+		if len(m.byKey) == 0 {
+			var zero V
+			return zero, false
+		}
+		value, ok := m.byKey[connection]
+		return value, ok
+	}
+
+	if i := m.index(equal, connection); i >= 0 {
+		return m.pairs[i].value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// set sets the connection's value. equal is the pool's Equal function.
+func (m *connMap[T, V]) set(equal func(a, b T) bool, connection T, value V) {
+	if equal == nil {
+		if m.byKey == nil {
+			m.byKey = make(map[any]V)
+		}
+		m.byKey[connection] = value
+		return
+	}
+
+	if i := m.index(equal, connection); i >= 0 {
+		m.pairs[i].value = value
+		return
+	}
+	m.pairs = append(m.pairs, connPair[T, V]{connection: connection, value: value})
+}
+
+// delete removes the connection's value, if it has one. equal is the pool's Equal function.
+func (m *connMap[T, V]) delete(equal func(a, b T) bool, connection T) {
+	if equal == nil {
+		if len(m.byKey) > 0 {
+			delete(m.byKey, connection)
+		}
+		return
+	}
+
+	if i := m.index(equal, connection); i >= 0 {
+		m.pairs = slices.Delete(m.pairs, i, i+1)
+	}
+}
+
+// clear removes every value.
+func (m *connMap[T, V]) clear() {
+	m.byKey = nil
+	m.pairs = nil
+}
+
+func (m *connMap[T, V]) index(equal func(a, b T) bool, connection T) int {
+	return slices.IndexFunc(m.pairs, func(pair connPair[T, V]) bool { return equal(pair.connection, connection) })
+}
@@ -0,0 +1,94 @@
+package connection_pool
+
+import (
+	"slices"
+	"time"
+)
+
+// PoolEventType is the kind of a PoolEvent.
+type PoolEventType int
+
+const (
+	// EventGet is recorded when a connection is checked out.
+	EventGet PoolEventType = iota
+	// EventPut is recorded when a connection is returned with Put, with the error it was returned with, if any.
+	EventPut
+	// EventCreate is recorded when a connection is created.
+	EventCreate
+	// EventClose is recorded when the pool closes a connection, with the error from closing it, if any.
+	EventClose
+	// EventError is recorded when creating a connection fails.
+	EventError
+)
+
+// String returns the name of the event type, e.g. "get".
+func (t PoolEventType) String() string {
+	switch t {
+	case EventGet:
+		return "get"
+	case EventPut:
+		return "put"
+	case EventCreate:
+		return "create"
+	case EventClose:
+		return "close"
+	case EventError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// PoolEvent is an entry in the event log enabled with EnableEventLog.
+type PoolEvent struct {
+	Type PoolEventType
+	Time time.Time
+	// Addr is the remote address of the connection, if it has a RemoteAddr method and the event concerns a connection.
+	Addr string
+	Err  error
+}
+
+// EnableEventLog starts recording the pool's connection events in memory, keeping the most recent maxEntries, for
+// inspection with EventLog. Calling it again discards the recorded events; a maxEntries of zero or less disables the
+// log.
+func (pool *ConnectionPool[T]) EnableEventLog(maxEntries int) {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	pool.events = nil
+	pool.eventsNext = 0
+	if maxEntries > 0 {
+		pool.events = make([]PoolEvent, 0, maxEntries)
+	}
+}
+
+// EventLog returns the recorded events, oldest first, or nil if the event log is not enabled.
+func (pool *ConnectionPool[T]) EventLog() []PoolEvent {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	if pool.events == nil {
+		return nil
+	}
+	return append(slices.Clone(pool.events[pool.eventsNext:]), pool.events[:pool.eventsNext]...)
+}
+
+// recordEvent adds an event to the event log, if it is enabled, overwriting the oldest event if the log is full. The
+// connection is nil for events that do not concern a connection. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) recordEvent(eventType PoolEventType, connection any, err error) {
+	if cap(pool.events) == 0 {
+		return
+	}
+
+	event := PoolEvent{Type: eventType, Time: time.Now(), Err: err}
+	if addr, ok := remoteAddr(connection); ok {
+		event.Addr = addr
+	}
+
+	if len(pool.events) < cap(pool.events) {
+		pool.events = append(pool.events, event)
+		return
+	}
+	pool.events[pool.eventsNext] = event
+	pool.eventsNext = (pool.eventsNext + 1) % len(pool.events)
+}
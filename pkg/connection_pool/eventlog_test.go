@@ -0,0 +1,83 @@
+package connection_pool_test
+
+import (
+	"errors"
+	"github.com/vphpersson/connection_pool/pkg/connection_pool"
+	"net"
+	"testing"
+)
+
+func TestConnectionPool_EventLog(t *testing.T) {
+	t.Parallel()
+
+	addr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 80}
+	pool := connection_pool.New(func() (*mockConnection, error) {
+		return &mockConnection{remoteAddr: addr}, nil
+	})
+
+	if events := pool.EventLog(); events != nil {
+		t.Fatalf("expected no events before the log is enabled, got %v", events)
+	}
+
+	pool.EnableEventLog(4)
+
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pool.Put(t.Context(), conn, nil)
+	conn, err = pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	putErr := errors.New("broken")
+	pool.Put(t.Context(), conn, putErr)
+
+	// The create and first get events have been overwritten.
+	events := pool.EventLog()
+	expected := []connection_pool.PoolEventType{
+		connection_pool.EventPut,
+		connection_pool.EventGet,
+		connection_pool.EventPut,
+		connection_pool.EventClose,
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %v", len(expected), events)
+	}
+	for i, event := range events {
+		if event.Type != expected[i] {
+			t.Fatalf("expected event %d to be %s, got %s", i, expected[i], event.Type)
+		}
+		if event.Addr != addr.String() {
+			t.Fatalf("expected event %d to have the address %s, got %q", i, addr, event.Addr)
+		}
+		if i > 0 && event.Time.Before(events[i-1].Time) {
+			t.Fatal("expected the events to be ordered oldest first")
+		}
+	}
+	if !errors.Is(events[2].Err, putErr) {
+		t.Fatalf("expected the put event to have the put error, got %v", events[2].Err)
+	}
+}
+
+func TestConnectionPool_EventLogCreateError(t *testing.T) {
+	t.Parallel()
+
+	dialErr := errors.New("dial failed")
+	pool := connection_pool.New(func() (*mockConnection, error) {
+		return nil, dialErr
+	})
+	pool.EnableEventLog(10)
+
+	if _, err := pool.Get(); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	events := pool.EventLog()
+	if len(events) != 1 || events[0].Type != connection_pool.EventError || !errors.Is(events[0].Err, dialErr) {
+		t.Fatalf("expected an error event with the dial error, got %v", events)
+	}
+	if events[0].Addr != "" {
+		t.Fatalf("expected no address, got %q", events[0].Addr)
+	}
+}
@@ -0,0 +1,38 @@
+package connection_pool
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Pinger checks that a connection is still alive, e.g. by sending a no-op request over it.
+type Pinger[T any] interface {
+	Ping(ctx context.Context, connection T) error
+}
+
+// KeepAlive configures the pinging of idle connections; see WithKeepAlive.
+type KeepAlive[T io.Closer] struct {
+	// Pinger pings the idle connections.
+	Pinger Pinger[T]
+	// Interval is how often the idle connections are pinged.
+	Interval time.Duration
+	// Timeout bounds how long a single ping may take. The connections are pinged one at a time, so it should be well
+	// below Interval. Zero uses a tenth of Interval.
+	Timeout time.Duration
+}
+
+// healthCheck returns an IdleHealthCheck that accepts the connections that answer a ping within the timeout.
+func (keepAlive KeepAlive[T]) healthCheck() func(T) bool {
+	timeout := keepAlive.Timeout
+	if timeout <= 0 {
+		timeout = keepAlive.Interval / 10
+	}
+
+	return func(connection T) bool {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		return keepAlive.Pinger.Ping(ctx, connection) == nil
+	}
+}
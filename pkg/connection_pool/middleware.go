@@ -0,0 +1,61 @@
+package connection_pool
+
+import (
+	"context"
+	"io"
+)
+
+// GetFunc checks out a connection, as GetContext does.
+type GetFunc[T io.Closer] func(ctx context.Context) (T, error)
+
+// Middleware wraps the checkout of connections, e.g. to add tracing, metrics or circuit breaking, by returning a
+// GetFunc that calls next.
+type Middleware[T io.Closer] func(next GetFunc[T]) GetFunc[T]
+
+// PutFunc returns a connection to the pool, as Put does.
+type PutFunc[T io.Closer] func(ctx context.Context, connection T, err error)
+
+// PutMiddleware wraps the return of connections, as Middleware does for their checkout.
+type PutMiddleware[T io.Closer] func(next PutFunc[T]) PutFunc[T]
+
+// Use adds middleware around the checkout of connections by Get, GetContext, GetDetailed, TryGet and Acquire. The
+// first middleware added is the outermost, i.e. it is called first and returns last. Middleware is called without the
+// pool's mutex held.
+func (pool *ConnectionPool[T]) Use(middleware ...Middleware[T]) {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	pool.getMiddleware = append(pool.getMiddleware, middleware...)
+}
+
+// UsePut adds middleware around the return of connections by Put and Release, in the same order as Use.
+func (pool *ConnectionPool[T]) UsePut(middleware ...PutMiddleware[T]) {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	pool.putMiddleware = append(pool.putMiddleware, middleware...)
+}
+
+// getChain returns get wrapped in the middleware added with Use.
+func (pool *ConnectionPool[T]) getChain(get GetFunc[T]) GetFunc[T] {
+	pool.mutex.Lock()
+	middleware := pool.getMiddleware
+	pool.unlock()
+
+	for i := len(middleware) - 1; i >= 0; i-- {
+		get = middleware[i](get)
+	}
+	return get
+}
+
+// putChain returns put wrapped in the middleware added with UsePut.
+func (pool *ConnectionPool[T]) putChain(put PutFunc[T]) PutFunc[T] {
+	pool.mutex.Lock()
+	middleware := pool.putMiddleware
+	pool.unlock()
+
+	for i := len(middleware) - 1; i >= 0; i-- {
+		put = middleware[i](put)
+	}
+	return put
+}
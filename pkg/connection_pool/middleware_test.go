@@ -0,0 +1,97 @@
+package connection_pool_test
+
+import (
+	"context"
+	"errors"
+	"github.com/vphpersson/connection_pool/pkg/connection_pool"
+	"testing"
+)
+
+func TestConnectionPool_Use(t *testing.T) {
+	t.Parallel()
+
+	pool := connection_pool.New(func() (*mockConnection, error) {
+		return newMockConnection()
+	})
+
+	var calls []string
+	record := func(name string) connection_pool.Middleware[*mockConnection] {
+		return func(next connection_pool.GetFunc[*mockConnection]) connection_pool.GetFunc[*mockConnection] {
+			return func(ctx context.Context) (*mockConnection, error) {
+				calls = append(calls, name+" before")
+				conn, err := next(ctx)
+				calls = append(calls, name+" after")
+				return conn, err
+			}
+		}
+	}
+	pool.Use(record("outer"), record("inner"))
+
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conn == nil {
+		t.Fatal("expected a connection")
+	}
+
+	expected := []string{"outer before", "inner before", "inner after", "outer after"}
+	if len(calls) != len(expected) {
+		t.Fatalf("expected calls %v, got %v", expected, calls)
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Fatalf("expected calls %v, got %v", expected, calls)
+		}
+	}
+}
+
+func TestConnectionPool_UseShortCircuits(t *testing.T) {
+	t.Parallel()
+
+	pool := connection_pool.New(func() (*mockConnection, error) {
+		return newMockConnection()
+	})
+
+	errOpen := errors.New("circuit open")
+	pool.Use(func(next connection_pool.GetFunc[*mockConnection]) connection_pool.GetFunc[*mockConnection] {
+		return func(ctx context.Context) (*mockConnection, error) {
+			return nil, errOpen
+		}
+	})
+
+	if _, _, err := pool.GetDetailed(t.Context()); !errors.Is(err, errOpen) {
+		t.Fatalf("expected the middleware's error, got %v", err)
+	}
+	if n := pool.TotalLen(); n != 0 {
+		t.Fatalf("expected no connection to be created, got %d", n)
+	}
+}
+
+func TestConnectionPool_UsePut(t *testing.T) {
+	t.Parallel()
+
+	pool := connection_pool.New(func() (*mockConnection, error) {
+		return newMockConnection()
+	})
+
+	// The middleware marks every returned connection as broken.
+	pool.UsePut(func(next connection_pool.PutFunc[*mockConnection]) connection_pool.PutFunc[*mockConnection] {
+		return func(ctx context.Context, conn *mockConnection, err error) {
+			next(ctx, conn, errors.New("broken"))
+		}
+	})
+
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pool.Put(t.Context(), conn, nil)
+
+	if !conn.isClosed {
+		t.Fatal("expected the connection to be closed with the middleware's error")
+	}
+	if n := pool.IdleLen(); n != 0 {
+		t.Fatalf("expected 0 idle connections, got %d", n)
+	}
+}
@@ -0,0 +1,128 @@
+package connection_pool
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// Option configures a pool at construction; see New.
+type Option[T io.Closer] func(*ConnectionPool[T])
+
+// WithMakeConnectionContext sets MakeConnectionContext.
+func WithMakeConnectionContext[T io.Closer](fn func(context.Context) (T, error)) Option[T] {
+	return func(pool *ConnectionPool[T]) {
+		pool.MakeConnectionContext = fn
+	}
+}
+
+// WithMaxConnections sets MaxNumConnections.
+func WithMaxConnections[T io.Closer](n int) Option[T] {
+	return func(pool *ConnectionPool[T]) {
+		pool.MaxNumConnections = n
+	}
+}
+
+// WithMinConnections sets MinNumConnections.
+func WithMinConnections[T io.Closer](n int) Option[T] {
+	return func(pool *ConnectionPool[T]) {
+		pool.MinNumConnections = n
+	}
+}
+
+// WithConnectionOrder sets Order.
+func WithConnectionOrder[T io.Closer](order ConnectionOrder) Option[T] {
+	return func(pool *ConnectionPool[T]) {
+		pool.Order = order
+	}
+}
+
+// WithIdleTimeout sets MaxConnectionIdleTime.
+func WithIdleTimeout[T io.Closer](d time.Duration) Option[T] {
+	return func(pool *ConnectionPool[T]) {
+		pool.MaxConnectionIdleTime = d
+	}
+}
+
+// WithMaxLifetime sets MaxConnectionLifetime.
+func WithMaxLifetime[T io.Closer](d time.Duration) Option[T] {
+	return func(pool *ConnectionPool[T]) {
+		pool.MaxConnectionLifetime = d
+	}
+}
+
+// WithHealthCheck sets ValidateConnection.
+func WithHealthCheck[T io.Closer](fn func(T) bool) Option[T] {
+	return func(pool *ConnectionPool[T]) {
+		pool.ValidateConnection = fn
+	}
+}
+
+// WithLogger sets Logger.
+func WithLogger[T io.Closer](logger *slog.Logger) Option[T] {
+	return func(pool *ConnectionPool[T]) {
+		pool.Logger = logger
+	}
+}
+
+// WithOnGet sets OnGet.
+func WithOnGet[T io.Closer](fn func(T)) Option[T] {
+	return func(pool *ConnectionPool[T]) {
+		pool.OnGet = fn
+	}
+}
+
+// WithOnPut sets OnPut.
+func WithOnPut[T io.Closer](fn func(T, error)) Option[T] {
+	return func(pool *ConnectionPool[T]) {
+		pool.OnPut = fn
+	}
+}
+
+// WithAutoScale sets AutoScale, ScaleStep, HardMax and ScaleDownDelay.
+func WithAutoScale[T io.Closer](step int, hardMax int, scaleDownDelay time.Duration) Option[T] {
+	return func(pool *ConnectionPool[T]) {
+		pool.AutoScale = true
+		pool.ScaleStep = step
+		pool.HardMax = hardMax
+		pool.ScaleDownDelay = scaleDownDelay
+	}
+}
+
+// WithOnCheckoutDuration sets OnCheckoutDuration.
+func WithOnCheckoutDuration[T io.Closer](fn func(T, time.Duration)) Option[T] {
+	return func(pool *ConnectionPool[T]) {
+		pool.OnCheckoutDuration = fn
+	}
+}
+
+// WithOnClose sets OnClose.
+func WithOnClose[T io.Closer](fn func(T, error)) Option[T] {
+	return func(pool *ConnectionPool[T]) {
+		pool.OnClose = fn
+	}
+}
+
+// WithBackgroundHealthCheck sets IdleHealthCheck and IdleHealthCheckInterval, making New start the background health
+// check.
+func WithBackgroundHealthCheck[T io.Closer](interval time.Duration, fn func(T) bool) Option[T] {
+	return func(pool *ConnectionPool[T]) {
+		pool.IdleHealthCheckInterval = interval
+		pool.IdleHealthCheck = fn
+	}
+}
+
+// WithKeepAlive makes New start the background health check with an IdleHealthCheck that pings each idle connection
+// every keepAlive.Interval. Connections that fail the ping, or do not answer it within keepAlive.Timeout, are closed
+// and discarded. It replaces any IdleHealthCheck set by WithBackgroundHealthCheck.
+func WithKeepAlive[T io.Closer](keepAlive KeepAlive[T]) Option[T] {
+	return WithBackgroundHealthCheck(keepAlive.Interval, keepAlive.healthCheck())
+}
+
+// WithMaxConcurrentCreates sets MaxConcurrentCreates.
+func WithMaxConcurrentCreates[T io.Closer](n int) Option[T] {
+	return func(pool *ConnectionPool[T]) {
+		pool.MaxConcurrentCreates = n
+	}
+}
@@ -0,0 +1,435 @@
+package connection_pool_test
+
+import (
+	"context"
+	"errors"
+	"github.com/vphpersson/connection_pool/pkg/connection_pool"
+	connectionPoolErrors "github.com/vphpersson/connection_pool/pkg/errors"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNew_Options(t *testing.T) {
+	t.Parallel()
+
+	healthCheck := func(*mockConnection) bool { return true }
+	logger := slog.New(slog.DiscardHandler)
+
+	pool := connection_pool.New(
+		func() (*mockConnection, error) {
+			return newMockConnection()
+		},
+		connection_pool.WithMaxConnections[*mockConnection](10),
+		connection_pool.WithMinConnections[*mockConnection](2),
+		connection_pool.WithIdleTimeout[*mockConnection](time.Minute),
+		connection_pool.WithMaxLifetime[*mockConnection](time.Hour),
+		connection_pool.WithHealthCheck(healthCheck),
+		connection_pool.WithLogger[*mockConnection](logger),
+	)
+
+	if pool.MaxNumConnections != 10 {
+		t.Fatalf("expected MaxNumConnections to be 10, got %d", pool.MaxNumConnections)
+	}
+	if pool.MinNumConnections != 2 {
+		t.Fatalf("expected MinNumConnections to be 2, got %d", pool.MinNumConnections)
+	}
+	if pool.MaxConnectionIdleTime != time.Minute {
+		t.Fatalf("expected MaxConnectionIdleTime to be 1m, got %v", pool.MaxConnectionIdleTime)
+	}
+	if pool.MaxConnectionLifetime != time.Hour {
+		t.Fatalf("expected MaxConnectionLifetime to be 1h, got %v", pool.MaxConnectionLifetime)
+	}
+	if pool.ValidateConnection == nil {
+		t.Fatal("expected ValidateConnection to be set")
+	}
+	if pool.Logger != logger {
+		t.Fatal("expected Logger to be set")
+	}
+}
+
+func TestNew_WithLogger(t *testing.T) {
+	t.Parallel()
+
+	handler := &errorContextHandler{Handler: slog.DiscardHandler, errs: make(chan error, 1)}
+	pool := connection_pool.New(
+		func() (*mockConnection, error) {
+			return newMockConnection()
+		},
+		connection_pool.WithLogger[*mockConnection](slog.New(handler)),
+	)
+
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = conn.Close()
+
+	// Closing the connection a second time fails, which the pool logs.
+	pool.Put(t.Context(), conn, errors.New("broken"))
+
+	select {
+	case <-handler.errs:
+	default:
+		t.Fatal("expected the close error to be logged to the pool's logger")
+	}
+}
+
+func TestNew_WithLifecycleHooks(t *testing.T) {
+	t.Parallel()
+
+	var gets, puts, closes []*mockConnection
+	var pool *connection_pool.ConnectionPool[*mockConnection]
+	pool = connection_pool.New(
+		func() (*mockConnection, error) {
+			return newMockConnection()
+		},
+		connection_pool.WithOnGet(func(conn *mockConnection) {
+			gets = append(gets, conn)
+		}),
+		connection_pool.WithOnPut(func(conn *mockConnection, err error) {
+			puts = append(puts, conn)
+		}),
+		connection_pool.WithOnClose(func(conn *mockConnection, err error) {
+			// Calling into the pool would deadlock if the hook were called with the mutex held.
+			_ = pool.TotalLen()
+			closes = append(closes, conn)
+		}),
+	)
+
+	conn1, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn2, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pool.Put(t.Context(), conn1, errors.New("broken"))
+	pool.Put(t.Context(), conn2, nil)
+
+	if err := pool.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(gets) != 2 || gets[0] != conn1 || gets[1] != conn2 {
+		t.Fatalf("expected OnGet for both connections, got %v", gets)
+	}
+	if len(puts) != 2 || puts[0] != conn1 || puts[1] != conn2 {
+		t.Fatalf("expected OnPut for both connections, got %v", puts)
+	}
+	if len(closes) != 2 || closes[0] != conn1 || closes[1] != conn2 {
+		t.Fatalf("expected OnClose for both connections, got %v", closes)
+	}
+}
+
+func TestNew_WithBackgroundHealthCheck(t *testing.T) {
+	t.Parallel()
+
+	var unhealthy atomic.Pointer[mockConnection]
+	pool := connection_pool.New(
+		func() (*mockConnection, error) {
+			return newMockConnection()
+		},
+		connection_pool.WithBackgroundHealthCheck(5*time.Millisecond, func(conn *mockConnection) bool {
+			return conn != unhealthy.Load()
+		}),
+	)
+	defer pool.Close()
+
+	conn1, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn2, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unhealthy.Store(conn1)
+	pool.Put(t.Context(), conn1, nil)
+	pool.Put(t.Context(), conn2, nil)
+
+	deadline := time.Now().Add(time.Second)
+	for pool.TotalLen() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the unhealthy connection to be discarded, got %d connections", pool.TotalLen())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conn != conn2 {
+		t.Fatal("expected the healthy connection to be kept")
+	}
+}
+
+func TestNew_WithBackgroundHealthCheckOneAtATime(t *testing.T) {
+	t.Parallel()
+
+	checking := make(chan *mockConnection)
+	unblock := make(chan struct{})
+	defer close(unblock)
+	pool := connection_pool.New(
+		func() (*mockConnection, error) {
+			return newMockConnection()
+		},
+		connection_pool.WithBackgroundHealthCheck(5*time.Millisecond, func(conn *mockConnection) bool {
+			select {
+			case checking <- conn:
+				<-unblock
+			case <-unblock:
+			}
+			return true
+		}),
+	)
+	defer pool.Close()
+
+	conn1, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn2, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pool.Put(t.Context(), conn1, nil)
+	pool.Put(t.Context(), conn2, nil)
+
+	checked := <-checking
+	if n := pool.IdleLen(); n != 1 {
+		t.Fatalf("expected the other connection to stay idle while one is checked, got %d idle connections", n)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	conn, err := pool.GetContext(ctx)
+	if err != nil {
+		t.Fatalf("expected the idle connection to be served during the check, got %v", err)
+	}
+	if conn == checked {
+		t.Fatal("expected the connection being checked not to be served")
+	}
+}
+
+func TestNewWithLimits(t *testing.T) {
+	t.Parallel()
+
+	pool := connection_pool.NewWithLimits(
+		func() (*mockConnection, error) {
+			return newMockConnection()
+		},
+		2,
+		4,
+	)
+
+	if n := pool.MaxConnections(); n != 4 {
+		t.Fatalf("expected a maximum of 4 connections, got %d", n)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for pool.IdleLen() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the pool to be warmed up to 2 idle connections, got %d", pool.IdleLen())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestNew_WithIdleTimeoutReaper(t *testing.T) {
+	t.Parallel()
+
+	pool := connection_pool.New(
+		func() (*mockConnection, error) {
+			return newMockConnection()
+		},
+		connection_pool.WithIdleTimeout[*mockConnection](10*time.Millisecond),
+	)
+	defer pool.Close()
+
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pool.Put(t.Context(), conn, nil)
+
+	deadline := time.Now().Add(time.Second)
+	for pool.IdleLen() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the expired idle connection to be reaped")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !conn.isClosed {
+		t.Fatal("expected the reaped connection to be closed")
+	}
+}
+
+type mockPinger struct {
+	dead atomic.Pointer[mockConnection]
+	// hung is a connection whose pings are not answered; hungFor records how long the last such ping waited.
+	hung    atomic.Pointer[mockConnection]
+	hungFor atomic.Int64
+}
+
+func (pinger *mockPinger) Ping(ctx context.Context, conn *mockConnection) error {
+	if conn == pinger.dead.Load() {
+		return errors.New("connection reset")
+	}
+	if conn == pinger.hung.Load() {
+		start := time.Now()
+		<-ctx.Done()
+		pinger.hungFor.Store(int64(time.Since(start)))
+	}
+	return ctx.Err()
+}
+
+func TestNew_WithKeepAlive(t *testing.T) {
+	t.Parallel()
+
+	pinger := &mockPinger{}
+	pool := connection_pool.New(
+		func() (*mockConnection, error) {
+			return newMockConnection()
+		},
+		connection_pool.WithKeepAlive(connection_pool.KeepAlive[*mockConnection]{
+			Pinger:   pinger,
+			Interval: 5 * time.Millisecond,
+		}),
+	)
+	defer pool.Close()
+
+	conn1, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn2, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pinger.dead.Store(conn1)
+	pool.Put(t.Context(), conn1, nil)
+	pool.Put(t.Context(), conn2, nil)
+
+	deadline := time.Now().Add(time.Second)
+	for pool.TotalLen() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the dead connection to be discarded, got %d connections", pool.TotalLen())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !conn1.isClosed {
+		t.Fatal("expected the dead connection to be closed")
+	}
+}
+
+func TestNew_WithKeepAliveTimeout(t *testing.T) {
+	t.Parallel()
+
+	pinger := &mockPinger{}
+	pool := connection_pool.New(
+		func() (*mockConnection, error) {
+			return newMockConnection()
+		},
+		connection_pool.WithKeepAlive(connection_pool.KeepAlive[*mockConnection]{
+			Pinger:   pinger,
+			Interval: 500 * time.Millisecond,
+			Timeout:  5 * time.Millisecond,
+		}),
+	)
+	defer pool.Close()
+
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pinger.hung.Store(conn)
+	pool.Put(t.Context(), conn, nil)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for pool.TotalLen() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the unresponsive connection to be discarded, got %d connections", pool.TotalLen())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if d := time.Duration(pinger.hungFor.Load()); d >= 500*time.Millisecond {
+		t.Fatalf("expected the ping to time out before the interval, waited %s", d)
+	}
+}
+
+func TestNew_WithOnCheckoutDuration(t *testing.T) {
+	t.Parallel()
+
+	var durations []time.Duration
+	pool := connection_pool.New(
+		func() (*mockConnection, error) {
+			return newMockConnection()
+		},
+		connection_pool.WithOnCheckoutDuration(func(conn *mockConnection, d time.Duration) {
+			durations = append(durations, d)
+		}),
+	)
+
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	pool.Put(t.Context(), conn, nil)
+
+	// A connection that the pool did not hand out has no checkout duration.
+	foreignConn, _ := newMockConnection()
+	pool.Put(t.Context(), foreignConn, nil)
+
+	if len(durations) != 1 {
+		t.Fatalf("expected 1 checkout duration, got %v", durations)
+	}
+	if durations[0] < 10*time.Millisecond {
+		t.Fatalf("expected a checkout duration of at least 10ms, got %v", durations[0])
+	}
+}
+
+func TestNewContext(t *testing.T) {
+	t.Parallel()
+
+	type tenantKey struct{}
+	var tenants []string
+	pool := connection_pool.NewContext(func(ctx context.Context) (*mockConnection, error) {
+		tenant, _ := ctx.Value(tenantKey{}).(string)
+		tenants = append(tenants, tenant)
+		return newMockConnection()
+	})
+
+	ctx := context.WithValue(t.Context(), tenantKey{}, "tenant-a")
+	if _, err := pool.GetContext(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := pool.Get(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(tenants) != 2 || tenants[0] != "tenant-a" || tenants[1] != "" {
+		t.Fatalf("expected MakeConnectionContext to get the getter's context, got tenants %q", tenants)
+	}
+}
+
+func TestNew_WithMakeConnectionContextConflict(t *testing.T) {
+	t.Parallel()
+
+	pool := connection_pool.New(
+		func() (*mockConnection, error) {
+			return newMockConnection()
+		},
+		connection_pool.WithMakeConnectionContext(func(context.Context) (*mockConnection, error) {
+			return newMockConnection()
+		}),
+	)
+
+	if _, err := pool.Get(); !errors.Is(err, connectionPoolErrors.ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig, got %v", err)
+	}
+}
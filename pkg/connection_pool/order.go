@@ -0,0 +1,51 @@
+package connection_pool
+
+import (
+	"fmt"
+	connectionPoolErrors "github.com/vphpersson/connection_pool/pkg/errors"
+)
+
+// ConnectionOrder is the order in which idle connections are reused.
+type ConnectionOrder int
+
+const (
+	// OrderLIFO reuses the most recently returned connection first, which keeps a small set of connections warm and
+	// lets the rest go idle long enough to be closed by MaxConnectionIdleTime.
+	OrderLIFO ConnectionOrder = iota
+	// OrderFIFO reuses the least recently returned connection first, which rotates through all idle connections so that
+	// none sits idle long enough to be dropped by a firewall or the backend.
+	OrderFIFO
+)
+
+// String returns "lifo" or "fifo".
+func (order ConnectionOrder) String() string {
+	switch order {
+	case OrderLIFO:
+		return "lifo"
+	case OrderFIFO:
+		return "fifo"
+	default:
+		return fmt.Sprintf("ConnectionOrder(%d)", int(order))
+	}
+}
+
+// MarshalText encodes the order as "lifo" or "fifo".
+func (order ConnectionOrder) MarshalText() ([]byte, error) {
+	if order != OrderLIFO && order != OrderFIFO {
+		return nil, fmt.Errorf("%w: connection order %d", connectionPoolErrors.ErrInvalidConfig, int(order))
+	}
+	return []byte(order.String()), nil
+}
+
+// UnmarshalText decodes "lifo" or "fifo".
+func (order *ConnectionOrder) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "lifo":
+		*order = OrderLIFO
+	case "fifo":
+		*order = OrderFIFO
+	default:
+		return fmt.Errorf("%w: connection order %q", connectionPoolErrors.ErrInvalidConfig, text)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package connection_pool
+
+import (
+	"container/list"
+	"context"
+)
+
+// Priority is the priority with which a getter waits for a connection, set on its context with WithPriority.
+type Priority int
+
+const (
+	// PriorityLow is the priority of getters whose context has no priority, e.g. batch work.
+	PriorityLow Priority = iota
+	// PriorityHigh is the priority of getters that are served before any PriorityLow getter, e.g. interactive
+	// requests.
+	PriorityHigh
+)
+
+type priorityContextKey struct{}
+
+// WithPriority returns a copy of ctx that makes a getter it is passed to wait for a connection with the priority.
+// Waiting getters are served in order of priority and then in the order they started waiting.
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityContextKey{}, priority)
+}
+
+// priorityFromContext returns the priority set on ctx with WithPriority, or PriorityLow if none is set.
+func priorityFromContext(ctx context.Context) Priority {
+	if priority, ok := ctx.Value(priorityContextKey{}).(Priority); ok {
+		return priority
+	}
+	return PriorityLow
+}
+
+// enqueueWaiter adds a getter to the waiters with the priority of its context, behind the waiters with the same or a
+// higher priority. The caller must hold the mutex.
+func (pool *ConnectionPool[T]) enqueueWaiter(ctx context.Context, w *waiter[T]) {
+	w.priority = priorityFromContext(ctx)
+
+	var lower *list.Element
+	for element := pool.waiters.Back(); element != nil; element = element.Prev() {
+		if element.Value.(*waiter[T]).priority >= w.priority {
+			break
+		}
+		lower = element
+	}
+
+	if lower != nil {
+		w.element = pool.waiters.InsertBefore(w, lower)
+	} else {
+		w.element = pool.waiters.PushBack(w)
+	}
+
+	pool.signalScaleUp()
+}
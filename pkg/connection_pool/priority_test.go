@@ -0,0 +1,58 @@
+package connection_pool_test
+
+import (
+	"github.com/vphpersson/connection_pool/pkg/connection_pool"
+	"testing"
+	"time"
+)
+
+func TestConnectionPool_WithPriority(t *testing.T) {
+	t.Parallel()
+
+	pool := connection_pool.New(func() (*mockConnection, error) {
+		return newMockConnection()
+	})
+	pool.MaxNumConnections = 1
+
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	getters := []struct {
+		name     string
+		priority connection_pool.Priority
+	}{
+		{name: "low", priority: connection_pool.PriorityLow},
+		{name: "high", priority: connection_pool.PriorityHigh},
+	}
+	served := make(chan string, len(getters))
+	for i, getter := range getters {
+		go func() {
+			ctx := connection_pool.WithPriority(t.Context(), getter.priority)
+			conn, err := pool.GetContext(ctx)
+			if err != nil {
+				served <- err.Error()
+				return
+			}
+			served <- getter.name
+			pool.Put(t.Context(), conn, nil)
+		}()
+
+		deadline := time.Now().Add(time.Second)
+		for pool.WaitingLen() != i+1 {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d waiting getters, got %d", i+1, pool.WaitingLen())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	pool.Put(t.Context(), conn, nil)
+
+	for _, expected := range []string{"high", "low"} {
+		if name := <-served; name != expected {
+			t.Fatalf("expected the %s priority getter to be served, got %s", expected, name)
+		}
+	}
+}
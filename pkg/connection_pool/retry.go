@@ -0,0 +1,48 @@
+package connection_pool
+
+import (
+	"math"
+	"time"
+)
+
+// RetryPolicy decides whether and when to retry a failed attempt, e.g. to create a connection or to use one.
+type RetryPolicy interface {
+	// NextDelay returns how long to wait before the next attempt, after attempt attempts have failed, and false if no
+	// more attempts should be made.
+	NextDelay(attempt int) (time.Duration, bool)
+}
+
+type exponentialBackoff struct {
+	base        time.Duration
+	maxDelay    time.Duration
+	maxAttempts int
+}
+
+// ExponentialBackoff returns a RetryPolicy that waits base after the first failed attempt and doubles the delay after
+// each further one, up to maxDelay if it is positive. At most maxAttempts attempts are made in total; zero or less
+// means no limit.
+func ExponentialBackoff(base, maxDelay time.Duration, maxAttempts int) RetryPolicy {
+	return &exponentialBackoff{base: base, maxDelay: maxDelay, maxAttempts: maxAttempts}
+}
+
+func (backoff *exponentialBackoff) NextDelay(attempt int) (time.Duration, bool) {
+	if backoff.maxAttempts > 0 && attempt >= backoff.maxAttempts {
+		return 0, false
+	}
+
+	limit := backoff.maxDelay
+	if limit <= 0 {
+		limit = math.MaxInt64
+	}
+
+	delay := min(backoff.base, limit)
+	for i := 1; i < attempt && delay < limit; i++ {
+		if delay > limit/2 {
+			delay = limit
+		} else {
+			delay *= 2
+		}
+	}
+
+	return delay, true
+}
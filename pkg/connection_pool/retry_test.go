@@ -0,0 +1,155 @@
+package connection_pool_test
+
+import (
+	"context"
+	"errors"
+	"github.com/vphpersson/connection_pool/pkg/connection_pool"
+	"testing"
+	"time"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	t.Parallel()
+
+	policy := connection_pool.ExponentialBackoff(10*time.Millisecond, 50*time.Millisecond, 5)
+
+	expected := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond}
+	for i, expectedDelay := range expected {
+		delay, ok := policy.NextDelay(i + 1)
+		if !ok {
+			t.Fatalf("expected attempt %d to be retried", i+1)
+		}
+		if delay != expectedDelay {
+			t.Fatalf("expected delay %v after attempt %d, got %v", expectedDelay, i+1, delay)
+		}
+	}
+
+	if _, ok := policy.NextDelay(5); ok {
+		t.Fatal("expected no retry after the last attempt")
+	}
+}
+
+func TestConnectionPool_RetryOnCreate(t *testing.T) {
+	t.Parallel()
+
+	t.Run("succeeds after failures", func(t *testing.T) {
+		t.Parallel()
+
+		numAttempts := 0
+		pool := connection_pool.New(func() (*mockConnection, error) {
+			numAttempts++
+			if numAttempts < 3 {
+				return nil, errors.New("dial failed")
+			}
+			return newMockConnection()
+		})
+		pool.RetryOnCreate = connection_pool.ExponentialBackoff(time.Millisecond, 0, 5)
+
+		if _, err := pool.Get(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if numAttempts != 3 {
+			t.Fatalf("expected 3 attempts, got %d", numAttempts)
+		}
+	})
+
+	t.Run("gives up", func(t *testing.T) {
+		t.Parallel()
+
+		numAttempts := 0
+		dialErr := errors.New("dial failed")
+		pool := connection_pool.New(func() (*mockConnection, error) {
+			numAttempts++
+			return nil, dialErr
+		})
+		pool.RetryOnCreate = connection_pool.ExponentialBackoff(time.Millisecond, 0, 3)
+
+		if _, err := pool.Get(); !errors.Is(err, dialErr) {
+			t.Fatalf("expected the creation error, got %v", err)
+		}
+		if numAttempts != 3 {
+			t.Fatalf("expected 3 attempts, got %d", numAttempts)
+		}
+		if n := pool.ActiveLen(); n != 0 {
+			t.Fatalf("expected the slot to be released, got %d active connections", n)
+		}
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		t.Parallel()
+
+		pool := connection_pool.New(func() (*mockConnection, error) {
+			return nil, errors.New("dial failed")
+		})
+		pool.RetryOnCreate = connection_pool.ExponentialBackoff(time.Hour, 0, 0)
+
+		ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+		defer cancel()
+
+		if _, err := pool.GetContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+		}
+	})
+}
+
+func TestConnectionPool_DoWithRetry(t *testing.T) {
+	t.Parallel()
+
+	t.Run("succeeds after retrying", func(t *testing.T) {
+		t.Parallel()
+
+		pool := connection_pool.New(func() (*mockConnection, error) {
+			return newMockConnection()
+		})
+
+		var used []*mockConnection
+		err := pool.DoWithRetry(
+			t.Context(),
+			connection_pool.ExponentialBackoff(time.Millisecond, 0, 3),
+			func(conn *mockConnection) error {
+				used = append(used, conn)
+				if len(used) < 3 {
+					return errors.New("transient failure")
+				}
+				return nil
+			},
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(used) != 3 {
+			t.Fatalf("expected 3 attempts, got %d", len(used))
+		}
+		if !used[0].isClosed || !used[1].isClosed || used[2].isClosed {
+			t.Fatal("expected only the connections of the failed attempts to be discarded")
+		}
+		if n := pool.IdleLen(); n != 1 {
+			t.Fatalf("expected 1 idle connection, got %d", n)
+		}
+	})
+
+	t.Run("gives up", func(t *testing.T) {
+		t.Parallel()
+
+		pool := connection_pool.New(func() (*mockConnection, error) {
+			return newMockConnection()
+		})
+
+		fnErr := errors.New("permanent failure")
+		numAttempts := 0
+		err := pool.DoWithRetry(
+			t.Context(),
+			connection_pool.ExponentialBackoff(time.Millisecond, 0, 2),
+			func(conn *mockConnection) error {
+				numAttempts++
+				return fnErr
+			},
+		)
+		if !errors.Is(err, fnErr) {
+			t.Fatalf("expected the function's error, got %v", err)
+		}
+		if numAttempts != 2 {
+			t.Fatalf("expected 2 attempts, got %d", numAttempts)
+		}
+	})
+}
@@ -0,0 +1,172 @@
+package connection_pool
+
+import (
+	"context"
+	"errors"
+	motmedelErrors "github.com/Motmedel/utils_go/pkg/errors"
+	connectionPoolErrors "github.com/vphpersson/connection_pool/pkg/errors"
+	"io"
+	"slices"
+	"sync"
+)
+
+var _ Pool[io.Closer] = (*ShardedPool[io.Closer])(nil)
+
+// ShardedPool spreads connections over several pools, one per backend, e.g. one per address that a name resolves to.
+// Getters are distributed over the shards round-robin, or, for a pool returned by Fallback, always start with the
+// first shard. Connections are returned to the shard they came from.
+type ShardedPool[T io.Closer] struct {
+	shards  []*ConnectionPool[T]
+	ordered bool
+	next    int
+	owners  []*shardedConnection[T]
+	mutex   sync.Mutex
+}
+
+// shardedConnection records the shard a checked-out connection came from.
+type shardedConnection[T io.Closer] struct {
+	connection T
+	shard      int
+}
+
+// NewSharded returns a pool with one shard per factory, each holding up to maxPerShard connections and configured by
+// the options.
+func NewSharded[T io.Closer](factories []func() (T, error), maxPerShard int, options ...Option[T]) *ShardedPool[T] {
+	shardOptions := append([]Option[T]{WithMaxConnections[T](maxPerShard)}, options...)
+
+	pool := &ShardedPool[T]{}
+	for _, factory := range factories {
+		pool.shards = append(pool.shards, New(factory, shardOptions...))
+	}
+
+	return pool
+}
+
+// Fallback returns a pool that checks out connections from pool and, when pool is exhausted, from fallback, e.g. for a
+// primary and a secondary region. Connections are returned to the pool they came from, and closing the returned pool
+// closes both.
+func (pool *ConnectionPool[T]) Fallback(fallback *ConnectionPool[T]) *ShardedPool[T] {
+	return &ShardedPool[T]{shards: []*ConnectionPool[T]{pool, fallback}, ordered: true}
+}
+
+// Get is like GetContext with a background context.
+func (pool *ShardedPool[T]) Get() (T, error) {
+	return pool.GetContext(context.Background())
+}
+
+// GetContext checks out a connection from the next shard in turn, or from the first shard for a pool returned by
+// Fallback. If that shard is exhausted, the other shards are tried in turn, and if all of them are exhausted, the
+// getter waits on the first one tried.
+func (pool *ShardedPool[T]) GetContext(ctx context.Context) (T, error) {
+	var zero T
+
+	if len(pool.shards) == 0 {
+		return zero, opError("get", nil, motmedelErrors.NewWithTrace(connectionPoolErrors.ErrNoConnectionsAvailable))
+	}
+
+	start := 0
+	if !pool.ordered {
+		pool.mutex.Lock()
+		start = pool.next
+		pool.next = (pool.next + 1) % len(pool.shards)
+		pool.mutex.Unlock()
+	}
+
+	for i := range pool.shards {
+		shard := (start + i) % len(pool.shards)
+		connection, err := pool.shards[shard].TryGet()
+		if errors.Is(err, connectionPoolErrors.ErrPoolExhausted) {
+			continue
+		}
+		if err != nil {
+			return zero, err
+		}
+		return pool.checkOut(connection, shard), nil
+	}
+
+	connection, err := pool.shards[start].GetContext(ctx)
+	if err != nil {
+		return zero, err
+	}
+
+	return pool.checkOut(connection, start), nil
+}
+
+// checkOut records the shard a connection came from.
+func (pool *ShardedPool[T]) checkOut(connection T, shard int) T {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	pool.owners = append(pool.owners, &shardedConnection[T]{connection: connection, shard: shard})
+
+	return connection
+}
+
+// Put returns a connection to the shard it came from, as ConnectionPool.Put does. A connection that did not come from
+// the pool is closed.
+func (pool *ShardedPool[T]) Put(ctx context.Context, connection T, err error) {
+	if io.Closer(connection) == nil {
+		return
+	}
+
+	pool.mutex.Lock()
+	shard := -1
+	i := slices.IndexFunc(pool.owners, func(owner *shardedConnection[T]) bool {
+		return pool.shards[owner.shard].equal(owner.connection, connection)
+	})
+	if i >= 0 {
+		shard = pool.owners[i].shard
+		pool.owners = slices.Delete(pool.owners, i, i+1)
+	}
+	pool.mutex.Unlock()
+
+	if shard < 0 {
+		_ = connection.Close()
+		return
+	}
+
+	pool.shards[shard].Put(ctx, connection, err)
+}
+
+// Close closes every shard. Errors from closing connections are joined and returned.
+func (pool *ShardedPool[T]) Close() error {
+	var errs []error
+	for _, shard := range pool.shards {
+		if err := shard.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// IdleLen returns the number of idle connections across all shards.
+func (pool *ShardedPool[T]) IdleLen() int {
+	n := 0
+	for _, shard := range pool.shards {
+		n += shard.IdleLen()
+	}
+
+	return n
+}
+
+// Stats returns the sum of the shards' statistics. Each shard's statistics are consistent, but the shards are read
+// one at a time.
+func (pool *ShardedPool[T]) Stats() Stats {
+	var stats Stats
+	for _, shard := range pool.shards {
+		shardStats := shard.Stats()
+		stats.IdleConnections += shardStats.IdleConnections
+		stats.ActiveConnections += shardStats.ActiveConnections
+		stats.WaitingGetters += shardStats.WaitingGetters
+		stats.TotalGets += shardStats.TotalGets
+		stats.TotalPuts += shardStats.TotalPuts
+		stats.TotalCreated += shardStats.TotalCreated
+		stats.TotalClosed += shardStats.TotalClosed
+		stats.TotalErrors += shardStats.TotalErrors
+		stats.TotalConnectionsCreated += shardStats.TotalConnectionsCreated
+		stats.TotalConnectionErrors += shardStats.TotalConnectionErrors
+	}
+
+	return stats
+}
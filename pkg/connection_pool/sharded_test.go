@@ -0,0 +1,129 @@
+package connection_pool_test
+
+import (
+	"github.com/vphpersson/connection_pool/pkg/connection_pool"
+	"testing"
+)
+
+func TestShardedPool(t *testing.T) {
+	t.Parallel()
+
+	var created [2][]*mockConnection
+	factory := func(shard int) func() (*mockConnection, error) {
+		return func() (*mockConnection, error) {
+			conn, err := newMockConnection()
+			created[shard] = append(created[shard], conn)
+			return conn, err
+		}
+	}
+	pool := connection_pool.NewSharded([]func() (*mockConnection, error){factory(0), factory(1)}, 1)
+
+	conn1, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn2, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(created[0]) != 1 || len(created[1]) != 1 {
+		t.Fatalf("expected one connection per shard, got %d and %d", len(created[0]), len(created[1]))
+	}
+
+	pool.Put(t.Context(), conn2, nil)
+	pool.Put(t.Context(), conn1, nil)
+	if n := pool.IdleLen(); n != 2 {
+		t.Fatalf("expected 2 idle connections, got %d", n)
+	}
+
+	// Each shard holds at most one connection, so both connections must have been returned to their own shard for
+	// them to be reused rather than replaced.
+	if _, err := pool.Get(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := pool.Get(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stats := pool.Stats()
+	if stats.TotalCreated != 2 || stats.ActiveConnections != 2 || stats.TotalGets != 4 {
+		t.Fatalf("expected 2 created, 2 active and 4 gets, got %+v", stats)
+	}
+
+	if err := pool.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestShardedPool_SkipsExhaustedShards(t *testing.T) {
+	t.Parallel()
+
+	pool := connection_pool.NewSharded(
+		[]func() (*mockConnection, error){newMockConnection, newMockConnection, newMockConnection},
+		1,
+	)
+
+	for range 3 {
+		if _, err := pool.Get(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if stats := pool.Stats(); stats.ActiveConnections != 3 {
+		t.Fatalf("expected 3 active connections, got %d", stats.ActiveConnections)
+	}
+}
+
+func TestShardedPool_PutForeignConnection(t *testing.T) {
+	t.Parallel()
+
+	pool := connection_pool.NewSharded([]func() (*mockConnection, error){newMockConnection}, 1)
+
+	conn, _ := newMockConnection()
+	pool.Put(t.Context(), conn, nil)
+	if !conn.isClosed {
+		t.Fatal("expected a connection that did not come from the pool to be closed")
+	}
+	if n := pool.IdleLen(); n != 0 {
+		t.Fatalf("expected 0 idle connections, got %d", n)
+	}
+}
+
+func TestConnectionPool_Fallback(t *testing.T) {
+	t.Parallel()
+
+	primary := connection_pool.New(newMockConnection, connection_pool.WithMaxConnections[*mockConnection](1))
+	secondary := connection_pool.New(newMockConnection, connection_pool.WithMaxConnections[*mockConnection](1))
+	pool := primary.Fallback(secondary)
+
+	conn1, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn2, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := primary.ActiveLen(); n != 1 {
+		t.Fatalf("expected 1 connection from the primary, got %d", n)
+	}
+	if n := secondary.ActiveLen(); n != 1 {
+		t.Fatalf("expected 1 connection from the fallback, got %d", n)
+	}
+
+	pool.Put(t.Context(), conn2, nil)
+	pool.Put(t.Context(), conn1, nil)
+	if n := primary.IdleLen(); n != 1 {
+		t.Fatalf("expected 1 idle connection in the primary, got %d", n)
+	}
+	if n := secondary.IdleLen(); n != 1 {
+		t.Fatalf("expected 1 idle connection in the fallback, got %d", n)
+	}
+
+	// The primary is preferred again as soon as it has a connection to offer.
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conn != conn1 {
+		t.Fatal("expected the primary's connection")
+	}
+}
@@ -0,0 +1,161 @@
+package connection_pool
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Stats is a snapshot of a pool's state and of counters accumulated since it was created.
+type Stats struct {
+	// IdleConnections is the number of idle connections.
+	IdleConnections int `json:"idle"`
+	// ActiveConnections is the number of connections that are checked out or being created.
+	ActiveConnections int `json:"active"`
+	// WaitingGetters is the number of getters waiting for a connection or for capacity to create one.
+	WaitingGetters int `json:"waiting"`
+
+	// TotalGets is the number of connections checked out.
+	TotalGets uint64 `json:"total_gets"`
+	// TotalPuts is the number of connections returned with Put.
+	TotalPuts uint64 `json:"total_puts"`
+	// TotalCreated is the number of connections created.
+	TotalCreated uint64 `json:"total_created"`
+	// TotalClosed is the number of connections closed by the pool.
+	TotalClosed uint64 `json:"total_closed"`
+	// TotalErrors is the number of failures to create a connection plus the number of connections returned with an
+	// error.
+	TotalErrors uint64 `json:"total_errors"`
+	// TotalConnectionsCreated is the number of connections created since the pool was created. Unlike TotalCreated,
+	// it is not zeroed by Reset.
+	TotalConnectionsCreated uint64 `json:"total_connections_created"`
+	// TotalConnectionErrors counts the same errors as TotalErrors since the pool was created. Unlike TotalErrors, it is
+	// not zeroed by Reset.
+	TotalConnectionErrors uint64 `json:"total_connection_errors"`
+}
+
+// Stats returns a consistent snapshot of the pool's state and counters.
+func (pool *ConnectionPool[T]) Stats() Stats {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	return Stats{
+		IdleConnections:   pool.connections.Len(),
+		ActiveConnections: pool.numActiveConnections,
+		WaitingGetters:    pool.waiters.Len(),
+		TotalGets:         pool.numGets,
+		TotalPuts:         pool.numPuts,
+		TotalCreated:      pool.numCreated,
+		TotalClosed:       pool.numClosed,
+		TotalErrors:       pool.numErrors,
+
+		TotalConnectionsCreated: pool.numConnectionsCreated.Load(),
+		TotalConnectionErrors:   pool.numConnectionErrors.Load(),
+	}
+}
+
+// PoolSnapshot is a detailed view of a pool's state for diagnostics, e.g. to be served as JSON.
+type PoolSnapshot struct {
+	IdleCount      int `json:"idle_count"`
+	ActiveCount    int `json:"active_count"`
+	WaitingCount   int `json:"waiting_count"`
+	MaxConnections int `json:"max_connections"`
+	MinConnections int `json:"min_connections"`
+
+	TotalCreated uint64 `json:"total_created"`
+	TotalErrors  uint64 `json:"total_errors"`
+
+	// OldestIdleAge is how long the connection that has been idle the longest has been idle, and AverageIdleAge the
+	// average of how long the idle connections have been idle. Both are zero if there are no idle connections.
+	OldestIdleAge  time.Duration `json:"oldest_idle_age"`
+	AverageIdleAge time.Duration `json:"average_idle_age"`
+}
+
+// Snapshot returns a detailed view of the pool's state. Unlike Stats, it walks the idle connections to compute their
+// ages, so it is meant for diagnostics rather than frequent polling.
+func (pool *ConnectionPool[T]) Snapshot() PoolSnapshot {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	snapshot := PoolSnapshot{
+		IdleCount:      pool.connections.Len(),
+		ActiveCount:    pool.numActiveConnections,
+		WaitingCount:   pool.waiters.Len(),
+		MaxConnections: pool.MaxNumConnections,
+		MinConnections: pool.MinNumConnections,
+		TotalCreated:   pool.numCreated,
+		TotalErrors:    pool.numErrors,
+	}
+
+	if snapshot.IdleCount == 0 {
+		return snapshot
+	}
+
+	now := time.Now()
+	var totalIdleAge time.Duration
+	for element := pool.connections.Front(); element != nil; element = element.Next() {
+		idleAge := now.Sub(element.Value.(*connEntry[T]).lastIdledAt)
+		snapshot.OldestIdleAge = max(snapshot.OldestIdleAge, idleAge)
+		totalIdleAge += idleAge
+	}
+	snapshot.AverageIdleAge = totalIdleAge / time.Duration(snapshot.IdleCount)
+
+	return snapshot
+}
+
+// TotalConnectionsCreated returns the number of connections created since the pool was created, as reported in
+// Stats. It does not acquire the pool mutex, so it can be polled by monitoring goroutines without contention.
+func (pool *ConnectionPool[T]) TotalConnectionsCreated() uint64 {
+	return pool.numConnectionsCreated.Load()
+}
+
+// String returns a summary of the pool's state for logs and test failure messages, e.g.
+// "ConnectionPool{idle:3, active:2, max:5, waiting:1}".
+func (pool *ConnectionPool[T]) String() string {
+	pool.mutex.Lock()
+	defer pool.unlock()
+
+	return fmt.Sprintf(
+		"ConnectionPool{idle:%d, active:%d, max:%d, waiting:%d}",
+		pool.connections.Len(),
+		pool.numActiveConnections,
+		pool.MaxNumConnections,
+		pool.waiters.Len(),
+	)
+}
+
+// StatsHandler returns an HTTP handler that responds with the pool's statistics as JSON, read from Stats on each
+// request.
+func (pool *ConnectionPool[T]) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := json.Marshal(pool.Stats())
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(data)
+	})
+}
+
+// ExpvarMap publishes the pool's statistics as an expvar.Map with the given name, e.g. for the /debug/vars endpoint,
+// and returns the map. Each value is read from Stats whenever it is read, on its own, so the values of one read of
+// the map may come from different moments; Stats and StatsHandler give a consistent snapshot. Like expvar.NewMap, it
+// panics if the name is already in use.
+func (pool *ConnectionPool[T]) ExpvarMap(name string) *expvar.Map {
+	m := expvar.NewMap(name)
+
+	m.Set("idle", expvar.Func(func() any { return pool.Stats().IdleConnections }))
+	m.Set("active", expvar.Func(func() any { return pool.Stats().ActiveConnections }))
+	m.Set("waiting", expvar.Func(func() any { return pool.Stats().WaitingGetters }))
+	m.Set("total_gets", expvar.Func(func() any { return pool.Stats().TotalGets }))
+	m.Set("total_puts", expvar.Func(func() any { return pool.Stats().TotalPuts }))
+	m.Set("total_created", expvar.Func(func() any { return pool.Stats().TotalCreated }))
+	m.Set("total_closed", expvar.Func(func() any { return pool.Stats().TotalClosed }))
+	m.Set("total_errors", expvar.Func(func() any { return pool.Stats().TotalErrors }))
+
+	return m
+}
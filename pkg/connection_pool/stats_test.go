@@ -0,0 +1,255 @@
+package connection_pool_test
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"github.com/vphpersson/connection_pool/pkg/connection_pool"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConnectionPool_Stats(t *testing.T) {
+	t.Parallel()
+
+	pool := connection_pool.New(func() (*mockConnection, error) {
+		return newMockConnection()
+	})
+	pool.MaxNumConnections = 2
+
+	conn1, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn2, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if conn, err := pool.Get(); err == nil {
+			pool.Put(t.Context(), conn, nil)
+		}
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for pool.Stats().WaitingGetters != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a waiting getter")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	stats := pool.Stats()
+	if stats.ActiveConnections != 2 || stats.IdleConnections != 0 {
+		t.Fatalf("expected 2 active and 0 idle connections, got %+v", stats)
+	}
+
+	pool.Put(t.Context(), conn1, errors.New("broken"))
+	<-done
+	pool.Put(t.Context(), conn2, nil)
+
+	stats = pool.Stats()
+	expected := connection_pool.Stats{
+		IdleConnections: 2,
+		TotalGets:       3,
+		TotalPuts:       3,
+		TotalCreated:    3,
+		TotalClosed:     1,
+		TotalErrors:     1,
+
+		TotalConnectionsCreated: 3,
+		TotalConnectionErrors:   1,
+	}
+	if stats != expected {
+		t.Fatalf("expected %+v, got %+v", expected, stats)
+	}
+}
+
+func TestConnectionPool_ExpvarMap(t *testing.T) {
+	t.Parallel()
+
+	pool := connection_pool.New(func() (*mockConnection, error) {
+		return newMockConnection()
+	})
+	// The name is unique per pool, as expvar names cannot be reused when the test is run repeatedly.
+	name := fmt.Sprintf("connection_pool_test_%p", pool)
+	m := pool.ExpvarMap(name)
+
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if value := m.Get("active").String(); value != "1" {
+		t.Fatalf("expected 1 active connection, got %s", value)
+	}
+
+	pool.Put(t.Context(), conn, nil)
+
+	if value := m.Get("idle").String(); value != "1" {
+		t.Fatalf("expected 1 idle connection, got %s", value)
+	}
+	if value := m.Get("total_created").String(); value != "1" {
+		t.Fatalf("expected 1 created connection, got %s", value)
+	}
+	if expvar.Get(name) != m {
+		t.Fatal("expected the map to be published")
+	}
+}
+
+func TestConnectionPool_StatsHandler(t *testing.T) {
+	t.Parallel()
+
+	pool := connection_pool.New(func() (*mockConnection, error) {
+		return newMockConnection()
+	})
+
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pool.Put(t.Context(), conn, nil)
+
+	recorder := httptest.NewRecorder()
+	pool.StatsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/stats", nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("expected content type application/json, got %q", contentType)
+	}
+
+	var stats connection_pool.Stats
+	if err := json.Unmarshal(recorder.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats != pool.Stats() {
+		t.Fatalf("expected %+v, got %+v", pool.Stats(), stats)
+	}
+}
+
+func TestConnectionPool_Snapshot(t *testing.T) {
+	t.Parallel()
+
+	pool := connection_pool.New(func() (*mockConnection, error) {
+		return newMockConnection()
+	})
+
+	if snapshot := pool.Snapshot(); snapshot.OldestIdleAge != 0 || snapshot.AverageIdleAge != 0 {
+		t.Fatalf("expected no idle ages without idle connections, got %+v", snapshot)
+	}
+
+	var connections []*mockConnection
+	for range 3 {
+		conn, err := pool.Get()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		connections = append(connections, conn)
+	}
+	pool.Put(t.Context(), connections[0], nil)
+	time.Sleep(20 * time.Millisecond)
+	pool.Put(t.Context(), connections[1], nil)
+
+	snapshot := pool.Snapshot()
+	if snapshot.IdleCount != 2 || snapshot.ActiveCount != 1 || snapshot.WaitingCount != 0 {
+		t.Fatalf("expected 2 idle and 1 active connection, got %+v", snapshot)
+	}
+	if snapshot.MaxConnections != 5 || snapshot.TotalCreated != 3 {
+		t.Fatalf("expected a maximum of 5 and 3 created connections, got %+v", snapshot)
+	}
+	if snapshot.OldestIdleAge < 20*time.Millisecond {
+		t.Fatalf("expected an oldest idle age of at least 20ms, got %v", snapshot.OldestIdleAge)
+	}
+	if snapshot.AverageIdleAge < 10*time.Millisecond || snapshot.AverageIdleAge >= snapshot.OldestIdleAge {
+		t.Fatalf("expected an average idle age between 10ms and %v, got %v", snapshot.OldestIdleAge, snapshot.AverageIdleAge)
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fields["idle_count"] != float64(2) {
+		t.Fatalf("expected idle_count to be 2, got %s", data)
+	}
+}
+
+func TestConnectionPool_String(t *testing.T) {
+	t.Parallel()
+
+	pool := connection_pool.New(func() (*mockConnection, error) {
+		return newMockConnection()
+	})
+
+	first, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := pool.Get(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pool.Put(t.Context(), first, nil)
+
+	expected := "ConnectionPool{idle:1, active:1, max:5, waiting:0}"
+	if s := fmt.Sprint(pool); s != expected {
+		t.Fatalf("expected %q, got %q", expected, s)
+	}
+}
+
+func TestConnectionPool_Reset(t *testing.T) {
+	t.Parallel()
+
+	pool := connection_pool.New(func() (*mockConnection, error) {
+		return newMockConnection()
+	})
+
+	idleConn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	activeConn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	brokenConn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pool.Put(t.Context(), idleConn, nil)
+	pool.Put(t.Context(), brokenConn, errors.New("broken"))
+
+	if err := pool.Reset(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !idleConn.isClosed {
+		t.Fatal("expected the idle connection to be closed")
+	}
+	// TotalConnectionsCreated and TotalConnectionErrors are not zeroed by Reset.
+	expected := connection_pool.Stats{ActiveConnections: 1, TotalConnectionsCreated: 3, TotalConnectionErrors: 1}
+	if stats := pool.Stats(); stats != expected {
+		t.Fatalf("expected %+v, got %+v", expected, stats)
+	}
+	if n := pool.TotalConnectionsCreated(); n != 3 {
+		t.Fatalf("expected 3 connections created, got %d", n)
+	}
+
+	pool.Put(t.Context(), activeConn, nil)
+	if n := pool.IdleLen(); n != 1 {
+		t.Fatalf("expected the pool to remain usable, got %d idle connections", n)
+	}
+	if _, err := pool.Get(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
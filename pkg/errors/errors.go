@@ -3,6 +3,36 @@ package errors
 import "errors"
 
 var (
-	ErrNilConnection = errors.New("nil connection")
-	ErrNilConnectionPool = errors.New("nil connection pool")
+	ErrNilConnection               = errors.New("nil connection")
+	ErrNilConnectionPool           = errors.New("nil connection pool")
+	ErrMaxCheckoutDurationExceeded = errors.New("max checkout duration exceeded")
+	ErrNoConnectionsAvailable      = errors.New("no connections available")
+	ErrCreationLimitReached        = errors.New("creation limit reached")
+	ErrPoolExhausted               = errors.New("pool exhausted")
+	ErrPoolDraining                = errors.New("pool draining")
+	ErrPoolClosed                  = errors.New("pool closed")
+	ErrMakeConnectionPanic         = errors.New("make connection panicked")
+	ErrConnectionRejected          = errors.New("connection rejected")
+	ErrInvalidConfig               = errors.New("invalid config")
 )
+
+// ConnectionPoolError is an error from a pool operation, recording the operation and the connection concerned, for
+// retrieval with errors.As.
+type ConnectionPoolError struct {
+	// Op is the operation that failed, e.g. "get", "put" or "close".
+	Op string
+	// Conn is the connection concerned, or nil if the error does not concern a particular connection.
+	Conn any
+	Err  error
+}
+
+func (e *ConnectionPoolError) Error() string {
+	if e.Err == nil {
+		return e.Op
+	}
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *ConnectionPoolError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,26 @@
+module github.com/vphpersson/connection_pool/pkg/prompool
+
+go 1.24.0
+
+require (
+	github.com/prometheus/client_golang v1.20.5
+	github.com/vphpersson/connection_pool v0.0.0-00010101000000-000000000000
+)
+
+require (
+	github.com/Motmedel/utils_go v0.0.205 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/kylelemons/godebug v1.1.0 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/prometheus/client_model v0.6.1 // indirect
+	github.com/prometheus/common v0.55.0 // indirect
+	github.com/prometheus/procfs v0.15.1 // indirect
+	golang.org/x/sys v0.22.0 // indirect
+	google.golang.org/protobuf v1.34.2 // indirect
+)
+
+// Builds within the repository use the parent module from the working tree. The replacement only applies when prompool
+// is the main module, so dependents use the version required above. It is a placeholder until the parent module has a
+// release with Stats.TotalConnectionErrors, and must be bumped to that release before prompool is depended on.
+replace github.com/vphpersson/connection_pool => ../..
@@ -0,0 +1,76 @@
+// Package prompool exposes the statistics of a connection pool as Prometheus metrics. It is a separate module so that
+// the connection_pool module does not depend on the Prometheus client.
+package prompool
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vphpersson/connection_pool/pkg/connection_pool"
+)
+
+// StatsSource is a pool whose statistics can be collected, such as a ConnectionPool or a ShardedPool.
+type StatsSource interface {
+	Stats() connection_pool.Stats
+}
+
+type collector struct {
+	pool StatsSource
+
+	idleConnections   *prometheus.Desc
+	activeConnections *prometheus.Desc
+	waitingGetters    *prometheus.Desc
+	createdTotal      *prometheus.Desc
+	errorsTotal       *prometheus.Desc
+}
+
+// NewPrometheusCollector returns a collector of the pool's statistics, with the labels attached to every metric. The
+// statistics are read from Stats whenever the collector is collected.
+func NewPrometheusCollector(pool StatsSource, labels prometheus.Labels) prometheus.Collector {
+	newDesc := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("connection_pool", "", name), help, nil, labels)
+	}
+
+	return &collector{
+		pool:              pool,
+		idleConnections:   newDesc("idle_connections", "Number of idle connections."),
+		activeConnections: newDesc("active_connections", "Number of connections checked out or being created."),
+		waitingGetters:    newDesc("waiting_getters", "Number of getters waiting for a connection."),
+		createdTotal:      newDesc("created_total", "Total number of connections created."),
+		errorsTotal: newDesc(
+			"errors_total",
+			"Total number of failures to create a connection and of connections returned with an error.",
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *collector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- c.idleConnections
+	descs <- c.activeConnections
+	descs <- c.waitingGetters
+	descs <- c.createdTotal
+	descs <- c.errorsTotal
+}
+
+// Collect implements prometheus.Collector.
+func (c *collector) Collect(metrics chan<- prometheus.Metric) {
+	stats := c.pool.Stats()
+
+	metrics <- prometheus.MustNewConstMetric(c.idleConnections, prometheus.GaugeValue, float64(stats.IdleConnections))
+	metrics <- prometheus.MustNewConstMetric(
+		c.activeConnections,
+		prometheus.GaugeValue,
+		float64(stats.ActiveConnections),
+	)
+	metrics <- prometheus.MustNewConstMetric(c.waitingGetters, prometheus.GaugeValue, float64(stats.WaitingGetters))
+	// TotalCreated and TotalErrors are zeroed by Reset, which a counter must not be.
+	metrics <- prometheus.MustNewConstMetric(
+		c.createdTotal,
+		prometheus.CounterValue,
+		float64(stats.TotalConnectionsCreated),
+	)
+	metrics <- prometheus.MustNewConstMetric(
+		c.errorsTotal,
+		prometheus.CounterValue,
+		float64(stats.TotalConnectionErrors),
+	)
+}
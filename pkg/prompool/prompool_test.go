@@ -0,0 +1,48 @@
+package prompool_test
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vphpersson/connection_pool/pkg/connection_pool"
+	"github.com/vphpersson/connection_pool/pkg/prompool"
+	"strings"
+	"testing"
+)
+
+type nopCloser struct{}
+
+func (*nopCloser) Close() error { return nil }
+
+func TestNewPrometheusCollector(t *testing.T) {
+	t.Parallel()
+
+	pool := connection_pool.New(func() (*nopCloser, error) {
+		return &nopCloser{}, nil
+	})
+	if _, err := pool.Get(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	collector := prompool.NewPrometheusCollector(pool, prometheus.Labels{"backend": "db"})
+
+	expected := `
+# HELP connection_pool_active_connections Number of connections checked out or being created.
+# TYPE connection_pool_active_connections gauge
+connection_pool_active_connections{backend="db"} 1
+# HELP connection_pool_created_total Total number of connections created.
+# TYPE connection_pool_created_total counter
+connection_pool_created_total{backend="db"} 1
+`
+	err := testutil.CollectAndCompare(
+		collector,
+		strings.NewReader(expected),
+		"connection_pool_active_connections",
+		"connection_pool_created_total",
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := testutil.CollectAndCount(collector); n != 5 {
+		t.Fatalf("expected 5 metrics, got %d", n)
+	}
+}
@@ -0,0 +1,69 @@
+// Package testutil provides test doubles for code that depends on a connection pool.
+package testutil
+
+import (
+	"context"
+	"github.com/vphpersson/connection_pool/pkg/connection_pool"
+	"io"
+	"sync/atomic"
+)
+
+var _ connection_pool.Pool[io.Closer] = (*MockPool[io.Closer])(nil)
+
+// MockPool is an implementation of connection_pool.Pool whose behavior is injected through its function fields, so
+// that code depending on a pool can be tested without creating real connections. A nil function field makes the
+// corresponding method a no-op that returns zero values. The call counters are safe for concurrent use.
+type MockPool[T io.Closer] struct {
+	// GetFunc is called by Get.
+	GetFunc func() (T, error)
+	// PutFunc is called by Put.
+	PutFunc func(ctx context.Context, connection T, err error)
+	// CloseFunc is called by Close.
+	CloseFunc func() error
+	// IdleLenFunc is called by IdleLen.
+	IdleLenFunc func() int
+
+	// GetCallCount is the number of calls to Get.
+	GetCallCount atomic.Int64
+	// PutCallCount is the number of calls to Put.
+	PutCallCount atomic.Int64
+}
+
+// Get calls GetFunc.
+func (pool *MockPool[T]) Get() (T, error) {
+	pool.GetCallCount.Add(1)
+
+	if pool.GetFunc == nil {
+		var zero T
+		return zero, nil
+	}
+
+	return pool.GetFunc()
+}
+
+// Put calls PutFunc.
+func (pool *MockPool[T]) Put(ctx context.Context, connection T, err error) {
+	pool.PutCallCount.Add(1)
+
+	if pool.PutFunc != nil {
+		pool.PutFunc(ctx, connection, err)
+	}
+}
+
+// Close calls CloseFunc.
+func (pool *MockPool[T]) Close() error {
+	if pool.CloseFunc == nil {
+		return nil
+	}
+
+	return pool.CloseFunc()
+}
+
+// IdleLen calls IdleLenFunc.
+func (pool *MockPool[T]) IdleLen() int {
+	if pool.IdleLenFunc == nil {
+		return 0
+	}
+
+	return pool.IdleLenFunc()
+}
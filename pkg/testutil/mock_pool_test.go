@@ -0,0 +1,64 @@
+package testutil_test
+
+import (
+	"context"
+	"errors"
+	"github.com/vphpersson/connection_pool/pkg/connection_pool"
+	"github.com/vphpersson/connection_pool/pkg/testutil"
+	"io"
+	"testing"
+)
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+func TestMockPool(t *testing.T) {
+	t.Parallel()
+
+	getErr := errors.New("get failed")
+	var putErr error
+	pool := &testutil.MockPool[io.Closer]{
+		GetFunc: func() (io.Closer, error) {
+			return nopCloser{}, getErr
+		},
+		PutFunc: func(ctx context.Context, connection io.Closer, err error) {
+			putErr = err
+		},
+	}
+
+	if _, err := pool.Get(); !errors.Is(err, getErr) {
+		t.Fatalf("expected %v, got %v", getErr, err)
+	}
+	pool.Put(t.Context(), nopCloser{}, context.Canceled)
+	if !errors.Is(putErr, context.Canceled) {
+		t.Fatalf("expected %v, got %v", context.Canceled, putErr)
+	}
+	if err := pool.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if n := pool.GetCallCount.Load(); n != 1 {
+		t.Fatalf("expected 1 call to Get, got %d", n)
+	}
+	if n := pool.PutCallCount.Load(); n != 1 {
+		t.Fatalf("expected 1 call to Put, got %d", n)
+	}
+}
+
+func TestMockPool_Zero(t *testing.T) {
+	t.Parallel()
+
+	var pool connection_pool.Pool[io.Closer] = &testutil.MockPool[io.Closer]{}
+
+	if connection, err := pool.Get(); connection != nil || err != nil {
+		t.Fatalf("expected zero values, got %v, %v", connection, err)
+	}
+	pool.Put(t.Context(), nil, nil)
+	if n := pool.IdleLen(); n != 0 {
+		t.Fatalf("expected 0 idle connections, got %d", n)
+	}
+	if err := pool.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}